@@ -0,0 +1,62 @@
+// Command grouter-graph renders a menu spec as a Graphviz DOT or Mermaid
+// diagram.
+//
+//	grouter-graph [-format dot|mermaid] menu.yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/SharkFourSix/grouter"
+)
+
+func main() {
+	formatName := flag.String("format", "dot", "output format: dot or mermaid")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-format dot|mermaid] <spec.json|spec.yaml>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), *formatName); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(specPath, formatName string) error {
+	format, err := grouter.ParseGraphFormat(formatName)
+	if err != nil {
+		return err
+	}
+	specs, err := grouter.LoadMenuSpec(os.DirFS(filepath.Dir(specPath)), filepath.Base(specPath))
+	if err != nil {
+		return err
+	}
+	// Handlers are only needed for routing, bind every name to a stub
+	handlers := grouter.HandlerRegistry{}
+	bindStubs(handlers, specs)
+	opts, err := grouter.BuildMenu(specs, handlers)
+	if err != nil {
+		return err
+	}
+	return grouter.WriteMenuGraph(os.Stdout, format, opts...)
+}
+
+func bindStubs(handlers grouter.HandlerRegistry, specs []*grouter.MenuSpec) {
+	for _, spec := range specs {
+		if spec == nil {
+			continue
+		}
+		if spec.Handler != "" {
+			handlers[spec.Handler] = func(request grouter.UssdRequest) bool { return false }
+		}
+		bindStubs(handlers, spec.Children)
+	}
+}
@@ -0,0 +1,75 @@
+package grouter
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Request whose context was extended by the engine with the matched route
+// and, if set, the timeout of the menu option.
+type contextRequest struct {
+	UssdRequest
+	ctx context.Context
+}
+
+func (r *contextRequest) Context() context.Context {
+	return r.ctx
+}
+
+func (r *contextRequest) Unwrap() UssdRequest {
+	return r.UssdRequest
+}
+
+// UnwrapRequest Returns the request created by the router, for requests that
+// the engine wraps before passing them on to handlers. Routers should use
+// this before asserting the type of a request.
+func UnwrapRequest(request UssdRequest) UssdRequest {
+	for {
+		wrapper, ok := request.(interface{ Unwrap() UssdRequest })
+		if !ok {
+			return request
+		}
+		request = wrapper.Unwrap()
+	}
+}
+
+// Signals when any of the watched deadlines passes
+type deadlineWatch struct {
+	expired chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	watched []context.Context
+}
+
+func newDeadlineWatch() *deadlineWatch {
+	return &deadlineWatch{expired: make(chan struct{})}
+}
+
+func (w *deadlineWatch) watch(ctx context.Context) {
+	if _, ok := ctx.Deadline(); !ok {
+		return
+	}
+	w.mu.Lock()
+	w.watched = append(w.watched, ctx)
+	w.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			w.once.Do(func() { close(w.expired) })
+		}
+	})
+}
+
+// Reports whether any of the watched deadlines has passed. Unlike the expired
+// channel, which is closed asynchronously, this is accurate as soon as the
+// deadline passes.
+func (w *deadlineWatch) exceeded() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ctx := range w.watched {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return true
+		}
+	}
+	return false
+}
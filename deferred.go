@@ -0,0 +1,199 @@
+package grouter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DeferredFunc A slow operation run in the background. The context is
+// cancelled when the session ends.
+type DeferredFunc func(ctx context.Context) (any, error)
+
+// DeferredHandler Shows the result of a deferred operation. The return value
+// has the same meaning as that of RouteHandler.
+type DeferredHandler func(request UssdRequest, result any, err error) bool
+
+const deferredKey = "grouter.deferred"
+
+// Pending operation. Operations run in the process that started them, so the
+// session only keeps the token they are registered with the engine under.
+type deferred struct {
+	done    chan struct{}
+	result  any
+	err     error
+	handler DeferredHandler
+	pending string
+	route   Route
+	cancel  context.CancelFunc
+	expiry  *time.Timer
+}
+
+// Operations started through the engine, by token
+type deferredOps struct {
+	mu  sync.Mutex
+	ops map[string]*deferred
+	seq uint64
+}
+
+const engineAttribute = "grouter.engine"
+
+// Defer Starts an operation that takes longer than the gateway allows in a
+// goroutine tied to the session, and responds with the pending text, e.g.
+// "Processing, press 1 to check status".
+//
+// The next input of the user, whatever its value, is routed to the deferred
+// handler once the operation completes. Until then, the pending text is shown
+// again. Only one operation can be pending per session; starting another one
+// cancels the previous operation. The deferred handler runs like the handler
+// of the option that started the operation, wrapped by the same middleware.
+//
+// The operation runs in the process that started it. When replicas share a
+// storage, the follow-up requests of the session must reach the same replica;
+// any other replica passes ErrDeferredNotFound to Engine.ErrorHandler.
+//
+// Operations are cancelled when the engine is closed, and dropped when the
+// user does not come back for them within the session eviction time set
+// through WithSessionTimes().
+func Defer(request UssdRequest, work DeferredFunc, h DeferredHandler, pending string) {
+	e, ok := request.GetAttribute(engineAttribute).(*Engine)
+	if !ok {
+		panic(fmt.Errorf("grouter.Defer: the request is not routed by an engine"))
+	}
+	session := request.Session()
+	e.cancelDeferred(session)
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &deferred{
+		done:    make(chan struct{}),
+		handler: h,
+		pending: pending,
+		cancel:  cancel,
+	}
+	if route := CurrentRoute(request); route != nil {
+		d.route = Route{Screen: route.Screen, Name: route.Name, Code: route.Code}
+	}
+	token := e.deferred.add(d, e.storageEviction)
+	session.Set(deferredKey, token)
+	go func() {
+		defer close(d.done)
+		defer cancel()
+		defer func() {
+			if p := recover(); p != nil {
+				d.err = &PanicError{Value: p}
+			}
+		}()
+		d.result, d.err = work(ctx)
+	}()
+	request.Continue("%s", pending)
+}
+
+// Registers the operation, which is cancelled and removed once it is not
+// accessed for the given time to live. Returns its token.
+func (o *deferredOps) add(d *deferred, ttl time.Duration) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.ops == nil {
+		o.ops = map[string]*deferred{}
+	}
+	o.seq++
+	token := strconv.FormatUint(o.seq, 10)
+	o.ops[token] = d
+	d.expiry = time.AfterFunc(ttl, func() {
+		if o.remove(token) == d {
+			d.cancel()
+		}
+	})
+	return token
+}
+
+// Returns the operation and extends its time to live
+func (o *deferredOps) get(token string, ttl time.Duration) *deferred {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	d, ok := o.ops[token]
+	if !ok {
+		return nil
+	}
+	d.expiry.Reset(ttl)
+	return d
+}
+
+// Removes the operation and returns it, or nil if it is not registered
+func (o *deferredOps) remove(token string) *deferred {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	d, ok := o.ops[token]
+	if !ok {
+		return nil
+	}
+	delete(o.ops, token)
+	d.expiry.Stop()
+	return d
+}
+
+// Cancels and removes all the operations
+func (o *deferredOps) cancelAll() {
+	o.mu.Lock()
+	ops := o.ops
+	o.ops = nil
+	o.mu.Unlock()
+	for _, d := range ops {
+		d.expiry.Stop()
+		d.cancel()
+	}
+}
+
+// Cancels the operation pending in the session, if any
+func (e *Engine) cancelDeferred(session UssdSession) {
+	var token string
+	if LoadSessionValue(session, deferredKey, &token) {
+		if d := e.deferred.remove(token); d != nil {
+			d.cancel()
+		}
+		session.Del(deferredKey)
+	}
+}
+
+// Routes the request to the pending deferred operation of the session, if
+// there is one.
+func (e *Engine) resumeDeferred(rc *routing) bool {
+	var (
+		session = rc.request.Session()
+		token   string
+	)
+	if !LoadSessionValue(session, deferredKey, &token) {
+		return false
+	}
+	d := e.deferred.get(token, e.storageEviction)
+	if d == nil {
+		session.Del(deferredKey)
+		e.Log.Printf("deferred=lost, token=%s", token)
+		if e.ErrorHandler(rc.request, ErrDeferredNotFound) == EndSession {
+			resetScreens(session)
+		}
+		return true
+	}
+	select {
+	case <-d.done:
+	default:
+		e.Log.Printf("deferred=pending, handler=%s", d.route.Name)
+		rc.request.Continue("%s", d.pending)
+		return true
+	}
+	e.deferred.remove(token)
+	session.Del(deferredKey)
+	e.Log.Printf("deferred=done, handler=%s", d.route.Name)
+	index := rc.menu.optionByName(d.route.Name)
+	if index == -1 {
+		if e.ErrorHandler(rc.request, ErrDeferredNotFound) == EndSession {
+			resetScreens(session)
+		}
+		return true
+	}
+	e.showHandler(rc, index, d.route.Screen, func(request UssdRequest) bool {
+		return d.handler(request, d.result, d.err)
+	})
+	return true
+}
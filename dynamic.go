@@ -0,0 +1,73 @@
+package grouter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MenuItem An item of a dynamic menu.
+type MenuItem struct {
+	// Text displayed to the user
+	Label string
+	// Application value associated with the item
+	Value any
+}
+
+// ItemProvider Returns the items of a dynamic menu for the current session.
+type ItemProvider func(request UssdRequest) []MenuItem
+
+// ItemHandler Handles the item chosen from a dynamic menu. The return value
+// has the same meaning as that of RouteHandler.
+type ItemHandler func(request UssdRequest, item MenuItem) bool
+
+// NewDynamicMenuOption Creates a menu option whose screen lists items built at
+// runtime.
+//
+// When the option is selected, the provider is called to build the items,
+// which are numbered from 1 and rendered below the title. The items are kept
+// in the session so that the user's choice is resolved against the list that
+// was displayed. The chosen item is then passed to the item handler.
+//
+// The sub options are added to the list screen and take precedence over the
+// item numbers when their codes are exact. The item handler runs in a screen
+// named `<name>.item`.
+func NewDynamicMenuOption(code string, title string, provider ItemProvider, h ItemHandler, name string, sub ...*MenuOption) *MenuOption {
+	if provider == nil || h == nil {
+		panic(fmt.Errorf("option: %s: dynamic menu requires an item provider and handler", name))
+	}
+	key := "grouter.items." + name
+	list := func(request UssdRequest) bool {
+		items := provider(request)
+		request.Session().Set(key, items)
+		request.Continue("%s", renderItems(title, items))
+		return false
+	}
+	choose := func(request UssdRequest) bool {
+		var items []MenuItem
+		LoadSessionValue(request.Session(), key, &items)
+		n, err := strconv.Atoi(request.Option())
+		if err != nil || n < 1 || n > len(items) {
+			request.Continue("%s", renderItems(title, items))
+			return true
+		}
+		return h(request, items[n-1])
+	}
+	item := NewMenuOption(`/^[0-9]+$/`, choose, name+".item")
+	return NewMenuOption(code, list, name, append([]*MenuOption{item}, sub...)...)
+}
+
+func renderItems(title string, items []MenuItem) string {
+	var sb strings.Builder
+	if !IsEmptyText(title) {
+		sb.WriteString(title)
+		sb.WriteString("\n\n")
+	}
+	for i, item := range items {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%d. %s", i+1, item.Label)
+	}
+	return sb.String()
+}
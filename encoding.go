@@ -0,0 +1,133 @@
+package grouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// LoadSessionValue Reads a session value into target, which must be a
+// pointer, and reports whether the value was found and converted.
+//
+// Storages that keep sessions outside of the process hand values back as
+// they were decoded, e.g. float64 for an int or []any for a slice. Such
+// values are converted to the type of the target through JSON. The engine
+// reads its own session values this way, and routers should too.
+func LoadSessionValue(session UssdSession, key string, target any) bool {
+	value, ok := session.Get(key)
+	if !ok || value == nil {
+		return false
+	}
+	dest := reflect.ValueOf(target).Elem()
+	if v := reflect.ValueOf(value); v.Type().AssignableTo(dest.Type()) {
+		dest.Set(v)
+		return true
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, target) == nil
+}
+
+// Session as encoded by EncodeSession()
+type sessionRecord struct {
+	ID           string         `json:"id"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	LastAccessed time.Time      `json:"lastAccessed"`
+	Values       map[string]any `json:"values"`
+}
+
+// EncodeSession Encodes a session as JSON, for storages that keep sessions
+// outside of the process, such as a cache shared by several replicas. The
+// session values must be JSON serializable.
+func EncodeSession(session UssdSession) ([]byte, error) {
+	record := sessionRecord{
+		ID:           session.ID(),
+		CreatedAt:    session.CreatedAt(),
+		LastAccessed: session.LastAccessed(),
+		Values:       map[string]any{},
+	}
+	for _, key := range session.Keys() {
+		if value, ok := session.Get(key); ok {
+			record.Values[key] = value
+		}
+	}
+	return json.Marshal(record)
+}
+
+// DecodeSession Decodes a session encoded by EncodeSession(). Values are
+// decoded as plain JSON values; see LoadSessionValue().
+func DecodeSession(data []byte) (UssdSession, error) {
+	var record sessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if record.Values == nil {
+		record.Values = map[string]any{}
+	}
+	return &decodedSession{record: record}, nil
+}
+
+type decodedSession struct {
+	mu     sync.RWMutex
+	record sessionRecord
+}
+
+func (s *decodedSession) ID() string {
+	return s.record.ID
+}
+
+func (s *decodedSession) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Values[key] = value
+}
+
+func (s *decodedSession) Get(key string) (any, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.record.Values[key]
+	return value, ok
+}
+
+func (s *decodedSession) MustGet(key string) any {
+	if value, ok := s.Get(key); ok {
+		return value
+	}
+	panic(fmt.Errorf("%s: not found", key))
+}
+
+func (s *decodedSession) Del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.record.Values, key)
+}
+
+func (s *decodedSession) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.record.Values))
+	for key := range s.record.Values {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *decodedSession) CreatedAt() time.Time {
+	return s.record.CreatedAt
+}
+
+func (s *decodedSession) LastAccessed() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.record.LastAccessed
+}
+
+func (s *decodedSession) Touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.LastAccessed = time.Now()
+}
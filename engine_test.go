@@ -0,0 +1,1328 @@
+package grouter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SharkFourSix/grouter"
+	"github.com/SharkFourSix/grouter/routers/at"
+)
+
+// Simulated USSD session against the Africa's Talking router
+type simulator struct {
+	t           *testing.T
+	handler     http.Handler
+	id          string
+	msisdn      string
+	serviceCode string
+	inputs      []string
+}
+
+func newSimulator(t *testing.T, handler http.Handler, id string) *simulator {
+	return &simulator{t: t, handler: handler, id: id, msisdn: "+265888000000", serviceCode: "*384*100#"}
+}
+
+// Sends the next input and returns the response body
+func (s *simulator) send(input ...string) string {
+	s.inputs = append(s.inputs, input...)
+	form := url.Values{
+		"text":        {strings.Join(s.inputs, "*")},
+		"sessionId":   {s.id},
+		"serviceCode": {s.serviceCode},
+		"phoneNumber": {s.msisdn},
+		"networkCode": {"65001"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/ussd", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	b, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return string(b)
+}
+
+func (s *simulator) expect(input string, prefix string) string {
+	s.t.Helper()
+	var resp string
+	if input == "" && len(s.inputs) == 0 {
+		resp = s.send()
+	} else {
+		resp = s.send(input)
+	}
+	if !strings.HasPrefix(resp, prefix) {
+		s.t.Fatalf("input %q: expected response starting with %q, got %q", input, prefix, resp)
+	}
+	return resp
+}
+
+func newTestEngine(t *testing.T, options ...grouter.RouterOption) *grouter.Engine {
+	e, err := grouter.NewRouterEngine(append([]grouter.RouterOption{grouter.WithRouter(at.RouterName)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close(context.Background()) })
+	return e
+}
+
+func continueWith(text string) grouter.RouteHandler {
+	return func(request grouter.UssdRequest) bool {
+		request.Continue(text)
+		return false
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	e := newTestEngine(t)
+	var trail []string
+	e.Use(func(next grouter.RouteHandler) grouter.RouteHandler {
+		return func(request grouter.UssdRequest) bool {
+			route := grouter.CurrentRoute(request)
+			trail = append(trail, route.Screen+">"+route.Name)
+			return next(request)
+		}
+	})
+	pin := func(next grouter.RouteHandler) grouter.RouteHandler {
+		return func(request grouter.UssdRequest) bool {
+			request.End("PIN required")
+			return false
+		}
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", continueWith("Balance"), "balance").Use(pin),
+		),
+	)
+	sim := newSimulator(t, e, "mw")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "END PIN required")
+	if strings.Join(trail, ",") != ">home,home>balance" {
+		t.Errorf("unexpected trail %v", trail)
+	}
+}
+
+func TestNavigationCodes(t *testing.T) {
+	e := newTestEngine(t, grouter.WithNavigationCodes("0", "00"))
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", continueWith("Account"), "account",
+				grouter.NewMenuOption("1", continueWith("Balance"), "balance"),
+			),
+		),
+	)
+	sim := newSimulator(t, e, "nav")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim.expect("1", "CON Balance")
+	sim.expect("0", "CON Account")
+	sim.expect("1", "CON Balance")
+	sim.expect("00", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim.expect("0", "CON Welcome")
+	sim.expect("0", "CON Welcome")
+}
+
+func TestDynamicMenuOption(t *testing.T) {
+	e := newTestEngine(t)
+	beneficiaries := func(request grouter.UssdRequest) []grouter.MenuItem {
+		return []grouter.MenuItem{{Label: "Alice", Value: "001"}, {Label: "Bob (10% off)", Value: "002"}}
+	}
+	pay := func(request grouter.UssdRequest, item grouter.MenuItem) bool {
+		if request.Input() == "" {
+			request.Prompt("Amount for %s", item.Label)
+		} else {
+			request.End("Sent %s to %s", request.Input(), item.Value)
+		}
+		return true
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewDynamicMenuOption("1", "Pay to:", beneficiaries, pay, "beneficiaries",
+				grouter.NewMenuOption("#", continueWith("Welcome"), "home"),
+			),
+		),
+	)
+	sim := newSimulator(t, e, "dyn")
+	sim.expect("", "CON Welcome")
+	if resp := sim.expect("1", "CON Pay to:"); !strings.Contains(resp, "1. Alice\n2. Bob (10% off)") {
+		t.Fatalf("unexpected list %q", resp)
+	}
+	sim.expect("3", "CON Pay to:")
+	sim.expect("2", "CON Amount for Bob (10% off)")
+	sim.expect("50", "END Sent 50 to 002")
+}
+
+func TestPagination(t *testing.T) {
+	e := newTestEngine(t, grouter.WithPagination(grouter.Pagination{
+		MaxLength: 60, MoreCode: "98", MoreLabel: "More", BackCode: "0", BackLabel: "Back",
+	}))
+	calls := 0
+	statement := func(request grouter.UssdRequest) bool {
+		calls++
+		request.Continue(grouter.NewLineStrings("Statement", "1. 24/07/31 5,000.00DR", "2. 24/03/01 9,000.00CR", "3. 24/02/04 2,000.00DR"))
+		return false
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", statement, "statement",
+			grouter.NewMenuOption("3", continueWith("Details"), "details"),
+		),
+	)
+	sim := newSimulator(t, e, "pages")
+	first := sim.expect("", "CON Statement")
+	if !strings.HasSuffix(first, "98. More\n") || strings.Contains(first, "0. Back") {
+		t.Fatalf("unexpected first page %q", first)
+	}
+	sim.expect("98", "CON 2. 24/03/01")
+	last := sim.expect("98", "CON 3. 24/02/04")
+	if strings.Contains(last, "98. More") || !strings.HasSuffix(last, "0. Back\n") {
+		t.Fatalf("unexpected last page %q", last)
+	}
+	sim.expect("0", "CON 2. 24/03/01")
+	sim.expect("3", "CON Details")
+	if calls != 1 {
+		t.Errorf("expected handler to be called once, got %d", calls)
+	}
+}
+
+func TestPaginationLongLines(t *testing.T) {
+	e := newTestEngine(t, grouter.WithPagination(grouter.DefaultPagination))
+	body := grouter.NewLineStrings("Terms", strings.Repeat("abcdefghij", 40), "Reply 1 to accept")
+	e.MenuOptions(grouter.NewMenuOption("", continueWith(body), "terms"))
+	sim := newSimulator(t, e, "pages-long")
+	var (
+		content []string
+		resp    = sim.send()
+	)
+	for i := 0; ; i++ {
+		if n := utf8.RuneCountInString(resp); n > grouter.DefaultPagination.MaxLength {
+			t.Fatalf("page %d is %d characters long: %q", i+1, n, resp)
+		}
+		if !strings.HasPrefix(resp, "CON ") {
+			t.Fatalf("unexpected page %q", resp)
+		}
+		page := strings.TrimSuffix(strings.TrimPrefix(resp, "CON "), "\n")
+		page = strings.TrimSuffix(page, "\n0. Back")
+		more := strings.HasSuffix(page, "\n98. More")
+		content = append(content, strings.TrimSuffix(page, "\n98. More"))
+		if !more {
+			break
+		}
+		if i > 10 {
+			t.Fatal("too many pages")
+		}
+		resp = sim.send("98")
+	}
+	if len(content) < 4 {
+		t.Errorf("expected at least 4 pages, got %d", len(content))
+	}
+	if joined := strings.ReplaceAll(strings.Join(content, ""), "\n", ""); joined != strings.ReplaceAll(body, "\n", "") {
+		t.Errorf("pages do not add up to the body:\n%q", content)
+	}
+	if resp := sim.send("0"); !strings.HasPrefix(resp, "CON "+content[len(content)-2]) {
+		t.Errorf("expected previous page, got %q", resp)
+	}
+}
+
+func TestForm(t *testing.T) {
+	e := newTestEngine(t)
+	required := func(input string) error {
+		if len(input) < 3 {
+			return errors.New("Invalid account")
+		}
+		return nil
+	}
+	transfer := grouter.NewForm(
+		func(request grouter.UssdRequest, values grouter.FormValues) bool {
+			request.End("Sent %s to %s", values["amount"], values["account"])
+			return false
+		},
+		grouter.FormField{Key: "account", Prompt: "Enter account", Validate: required},
+		grouter.FormField{Key: "amount", Prompt: "Enter amount"},
+	).ConfirmText(func(values grouter.FormValues) string {
+		return "Send " + values["amount"] + "?\n1. Yes\n2. No"
+	})
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", transfer.Handler(), "transfer"),
+		),
+	)
+	sim := newSimulator(t, e, "form")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Enter account")
+	sim.expect("12", "CON Invalid account\nEnter account")
+	sim.expect("123", "CON Enter amount")
+	sim.expect("#", "CON Enter account")
+	sim.expect("456", "CON Enter amount")
+	sim.expect("50", "CON Send 50?")
+	sim.expect("1", "END Sent 50 to 456")
+}
+
+func TestMenuSpec(t *testing.T) {
+	handlers := grouter.HandlerRegistry{
+		"accountBalance": accountBalance,
+		"endSession":     endSession,
+	}
+	e := newTestEngine(t,
+		grouter.WithTemplateFS(os.DirFS("./testdata/templates"), ".", template.FuncMap{}),
+		grouter.WithMenuSpec(os.DirFS("./testdata"), "menu.yaml", handlers),
+	)
+	sim := newSimulator(t, e, "spec")
+	sim.expect("", "CON Welcome\n1. My Account\n#. Exit")
+	sim.expect("1", "CON Select option:")
+	sim.expect("1", "CON Balance for")
+	sim.expect("#", "CON Select option:")
+
+	sim = newSimulator(t, e, "spec-exit")
+	sim.expect("", "CON Welcome")
+	sim.expect("#", "END Thank you")
+
+	specs, err := grouter.LoadMenuSpec(os.DirFS("./testdata"), "menu.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(handlers, "endSession")
+	if err := grouter.ValidateMenuSpec(specs, handlers); err == nil {
+		t.Errorf("expected missing handler error")
+	}
+
+	// Templates receive the subscriber's number like the handlers of the example
+	specs, err = grouter.ParseMenuSpec([]byte(`[{"code": "", "name": "welcome", "template": "main.tmpl"}]`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := grouter.BuildMenu(specs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = newTestEngine(t, grouter.WithTemplateFS(os.DirFS("./testdata/templates"), ".", template.FuncMap{}))
+	e.MenuOptions(opts...)
+	newSimulator(t, e, "spec-template").expect("", "CON Welcome +265888000000")
+}
+
+func TestWriteGraph(t *testing.T) {
+	e := newTestEngine(t)
+	e.MenuOptions(
+		grouter.NewMenuOption("", welcomeScreen, "welcomeScreen",
+			grouter.NewMenuOption("1", showAccount, "accountMenu",
+				grouter.NewMenuOption("#", welcomeScreen, "welcomeScreen"),
+			),
+		),
+	)
+	var sb strings.Builder
+	if err := e.WriteGraph(&sb, grouter.GraphDOT); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`n1 [label="welcomeScreen\ngrouter_test.welcomeScreen", shape=box];`,
+		`n1 -> n2 [label="1"];`,
+		`n2 -> n1 [label="#"];`,
+	} {
+		if !strings.Contains(sb.String(), expected) {
+			t.Errorf("expected %s in:\n%s", expected, sb.String())
+		}
+	}
+	sb.Reset()
+	if err := e.WriteGraph(&sb, grouter.GraphMermaid); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), `n0 -->|"dial"| n1`) {
+		t.Errorf("unexpected mermaid output:\n%s", sb.String())
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	e := newTestEngine(t)
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+				request.Continue("discarded")
+				request.Redirect("account")
+				return true
+			}, "shortcut"),
+			grouter.NewMenuOption("2", continueWith("Account"), "account",
+				grouter.NewMenuOption("1", continueWith("Balance"), "balance"),
+			),
+			grouter.NewMenuOption("3", func(request grouter.UssdRequest) bool {
+				request.Redirect("loop")
+				return false
+			}, "loop"),
+		),
+	)
+	sim := newSimulator(t, e, "redirect")
+	sim.expect("", "CON Welcome")
+	if resp := sim.expect("1", "CON Account"); strings.Contains(resp, "discarded") {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	sim.expect("1", "CON Balance")
+
+	sim = newSimulator(t, e, "redirect-loop")
+	sim.expect("", "CON Welcome")
+	sim.expect("3", "END Session terminated")
+}
+
+func TestGlobalOptions(t *testing.T) {
+	e := newTestEngine(t)
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", continueWith("Account"), "account",
+				grouter.NewMenuOption("99", continueWith("Account 99"), "account99"),
+			),
+			grouter.NewMenuOption("2", func(request grouter.UssdRequest) bool {
+				if request.Input() == "" {
+					request.Prompt("Enter amount")
+				} else {
+					request.End("Amount %s", request.Input())
+				}
+				return true
+			}, "amount"),
+		),
+	)
+	e.GlobalOptions(
+		grouter.NewMenuOption("99", continueWith("Main menu"), "mainMenu"),
+		grouter.NewMenuOption(`/^\*$/`, continueWith("Help"), "help",
+			grouter.NewMenuOption("1", continueWith("Help topic"), "helpTopic"),
+		),
+	)
+	sim := newSimulator(t, e, "global")
+	sim.expect("", "CON Welcome")
+	sim.expect("99", "CON Main menu")
+	sim = newSimulator(t, e, "global-help")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim.expect("99", "CON Account 99")
+	sim.expect("*", "CON Help")
+	sim.expect("1", "CON Help topic")
+
+	sim = newSimulator(t, e, "global-prompt")
+	sim.expect("", "CON Welcome")
+	sim.expect("2", "CON Enter amount")
+	sim.expect("99", "END Amount 99")
+}
+
+func TestServiceMenu(t *testing.T) {
+	e := newTestEngine(t)
+	e.MenuOptions(grouter.NewMenuOption("", continueWith("Fallback"), "home"))
+	e.ServiceMenu("*384*200#",
+		grouter.NewMenuOption("", continueWith("Loans"), "home",
+			grouter.NewMenuOption("1", continueWith("Apply"), "apply"),
+		),
+	)
+	e.GlobalOptions(grouter.NewMenuOption("99", continueWith("Help"), "help"))
+
+	sim := newSimulator(t, e, "service-fallback")
+	sim.expect("", "CON Fallback")
+	sim.expect("1", "END Invalid option")
+
+	sim = newSimulator(t, e, "service-loans")
+	sim.serviceCode = "*384*200#"
+	sim.expect("", "CON Loans")
+	sim.expect("1", "CON Apply")
+	sim.expect("99", "CON Help")
+}
+
+func TestOutcomes(t *testing.T) {
+	e := newTestEngine(t)
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", continueWith("Account"), "account",
+				grouter.NewOutcomeMenuOption("1", func(request grouter.UssdRequest) grouter.Outcome {
+					request.Continue("discarded")
+					return grouter.Back
+				}, "cancel"),
+				grouter.NewOutcomeMenuOption("2", func(request grouter.UssdRequest) grouter.Outcome {
+					return grouter.Redirect("statement")
+				}, "shortcut"),
+				grouter.NewOutcomeMenuOption("3", func(request grouter.UssdRequest) grouter.Outcome {
+					return grouter.Home
+				}, "goHome"),
+			),
+			grouter.NewMenuOption("2", continueWith("Statement"), "statement"),
+			grouter.NewOutcomeMenuOption("3", func(request grouter.UssdRequest) grouter.Outcome {
+				request.End("Bye")
+				return grouter.EndSession
+			}, "exit"),
+		),
+	)
+	sim := newSimulator(t, e, "outcomes")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim.expect("1", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim.expect("2", "CON Statement")
+
+	sim = newSimulator(t, e, "outcomes-home")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim.expect("3", "CON Welcome")
+	sim.expect("3", "END Bye")
+}
+
+func TestErrorHandler(t *testing.T) {
+	errUnavailable := errors.New("core banking unavailable")
+	e := newTestEngine(t)
+	var handled []error
+	e.ErrorHandler = func(request grouter.UssdRequest, err error) grouter.Outcome {
+		handled = append(handled, err)
+		if errors.Is(err, errUnavailable) {
+			request.Continue("Service unavailable, try again")
+			return grouter.Stay
+		}
+		request.End("Sorry")
+		return grouter.EndSession
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOptionE("1", func(request grouter.UssdRequest) error {
+				request.Continue("discarded")
+				return errUnavailable
+			}, "balance"),
+			grouter.NewMenuOption("2", func(request grouter.UssdRequest) bool {
+				panic("boom")
+			}, "statement"),
+		),
+	)
+	sim := newSimulator(t, e, "errors")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Service unavailable, try again")
+	sim.expect("2", "END Sorry")
+	var panicErr *grouter.PanicError
+	if len(handled) != 2 || !errors.As(handled[1], &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("unexpected errors %v", handled)
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	e := newTestEngine(t, grouter.WithResponseDeadline(time.Second, "Taking too long"))
+	slow := func(request grouter.UssdRequest) bool {
+		<-request.Context().Done()
+		request.Continue("too late")
+		return false
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", func(request grouter.UssdRequest) bool {
+			if _, ok := request.Context().Deadline(); !ok {
+				request.End("no deadline")
+			} else {
+				request.Continue("Welcome")
+			}
+			return false
+		}, "home",
+			grouter.NewMenuOption("1", slow, "slow").Timeout(20*time.Millisecond),
+		),
+	)
+	var (
+		mu     sync.Mutex
+		events []string
+		routed = make(chan struct{}, 2)
+	)
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	e.Hooks.ScreenEnter = func(request grouter.UssdRequest, screen string) { record("enter:" + screen) }
+	e.Hooks.ScreenLeave = func(request grouter.UssdRequest, screen string) { record("leave:" + screen) }
+	e.Hooks.SessionEnd = func(session grouter.UssdSession, reason grouter.SessionEndReason) { record("end:" + reason.String()) }
+	grouter.OnRouted(e, func() { routed <- struct{}{} })
+	sim := newSimulator(t, e, "deadline")
+	sim.expect("", "CON Welcome")
+	<-routed
+	started := time.Now()
+	sim.expect("1", "END Taking too long")
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("option timeout not applied, took %s", elapsed)
+	}
+
+	// The abandoned handler no longer changes the screen of the session
+	select {
+	case <-routed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the abandoned handler to return")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"enter:home", "end:ended"}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected events %v, expected %v", events, expected)
+	}
+}
+
+func TestDefer(t *testing.T) {
+	e := newTestEngine(t)
+	release := make(chan struct{})
+	calls := 0
+	counted := func(next grouter.RouteHandler) grouter.RouteHandler {
+		return func(request grouter.UssdRequest) bool {
+			calls++
+			return next(request)
+		}
+	}
+	var failed string
+	e.ErrorHandler = func(request grouter.UssdRequest, err error) grouter.Outcome {
+		var panicErr *grouter.PanicError
+		if errors.As(err, &panicErr) {
+			failed = grouter.CurrentRoute(request).Name
+		}
+		request.End("Failed")
+		return grouter.EndSession
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+				grouter.Defer(request, func(ctx context.Context) (any, error) {
+					<-release
+					return "MWK 41,000.00", nil
+				}, func(request grouter.UssdRequest, result any, err error) bool {
+					request.End("Balance: %s (%s)", result, grouter.CurrentRoute(request).Name)
+					return false
+				}, "Processing, press 1 to check status")
+				return true
+			}, "balance").Use(counted),
+			grouter.NewMenuOption("2", func(request grouter.UssdRequest) bool {
+				grouter.Defer(request, func(ctx context.Context) (any, error) {
+					return nil, nil
+				}, func(request grouter.UssdRequest, result any, err error) bool {
+					panic("failure")
+				}, "Processing")
+				return true
+			}, "broken"),
+		),
+	)
+	sim := newSimulator(t, e, "defer")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Processing")
+	sim.expect("1", "CON Processing")
+	close(release)
+	resp := sim.send("1")
+	for deadline := time.Now().Add(time.Second); strings.HasPrefix(resp, "CON Processing") && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+		resp = sim.send("1")
+	}
+	if resp != "END Balance: MWK 41,000.00 (balance)\n" {
+		t.Errorf("unexpected response %q", resp)
+	}
+	if calls != 2 {
+		t.Errorf("expected middleware to wrap the deferred handler, got %d calls", calls)
+	}
+
+	sim = newSimulator(t, e, "defer-panic")
+	sim.expect("", "CON Welcome")
+	sim.expect("2", "CON Processing")
+	resp = sim.send("1")
+	for deadline := time.Now().Add(time.Second); strings.HasPrefix(resp, "CON Processing") && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+		resp = sim.send("1")
+	}
+	if resp != "END Failed\n" || failed != "broken" {
+		t.Errorf("expected the panic to reach the error handler, got %q (route %q)", resp, failed)
+	}
+}
+
+func TestDeferCancellation(t *testing.T) {
+	cancelled := make(chan string, 1)
+	menu := func(e *grouter.Engine) {
+		e.MenuOptions(
+			grouter.NewMenuOption("", continueWith("Welcome"), "home",
+				grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+					id := request.Session().ID()
+					grouter.Defer(request, func(ctx context.Context) (any, error) {
+						<-ctx.Done()
+						cancelled <- id
+						return nil, ctx.Err()
+					}, func(request grouter.UssdRequest, result any, err error) bool {
+						request.End("Done")
+						return false
+					}, "Processing")
+					return true
+				}, "slow"),
+			),
+		)
+	}
+	wait := func() string {
+		t.Helper()
+		select {
+		case id := <-cancelled:
+			return id
+		case <-time.After(time.Second):
+			t.Fatal("expected the operation to be cancelled")
+			return ""
+		}
+	}
+
+	// Operations the user does not come back for are dropped after the
+	// session eviction time
+	e := newTestEngine(t, grouter.WithSessionTimes(time.Hour, 10*time.Millisecond))
+	menu(e)
+	sim := newSimulator(t, e, "defer-expired")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Processing")
+	if id := wait(); id != "defer-expired" {
+		t.Errorf("unexpected session %q", id)
+	}
+	sim.expect("1", "END Session terminated due to internal error")
+
+	// and pending operations are cancelled when the engine is closed
+	e = newTestEngine(t)
+	menu(e)
+	sim = newSimulator(t, e, "defer-closed")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Processing")
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if id := wait(); id != "defer-closed" {
+		t.Errorf("unexpected session %q", id)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	e := newTestEngine(t, grouter.WithSessionTimes(time.Hour, time.Hour))
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	e.Hooks = grouter.Hooks{
+		SessionStart: func(request grouter.UssdRequest) { record("start:" + request.Session().ID()) },
+		SessionEnd: func(session grouter.UssdSession, reason grouter.SessionEndReason) {
+			record("end:" + session.ID() + ":" + reason.String())
+		},
+		ScreenEnter: func(request grouter.UssdRequest, screen string) { record("enter:" + screen) },
+		ScreenLeave: func(request grouter.UssdRequest, screen string) { record("leave:" + screen) },
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", continueWith("Account"), "account"),
+			grouter.NewMenuOption("2", func(request grouter.UssdRequest) bool {
+				request.End("Bye")
+				return false
+			}, "exit"),
+		),
+	)
+	sim := newSimulator(t, e, "hooks")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim = newSimulator(t, e, "hooks-end")
+	sim.expect("", "CON Welcome")
+	sim.expect("2", "END Bye")
+	// Evicts the remaining session without waiting for the sweeper
+	e.Storage.Vacuum(time.Nanosecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{
+		"start:hooks", "enter:home", "leave:home", "enter:account",
+		"start:hooks-end", "enter:home", "leave:home", "enter:exit", "end:hooks-end:ended",
+		"end:hooks:evicted",
+	}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected events:\n%v\nexpected:\n%v", events, expected)
+	}
+}
+
+func TestEndCleanup(t *testing.T) {
+	menu := func(e *grouter.Engine) {
+		e.MenuOptions(
+			grouter.NewMenuOption("", continueWith("Welcome"), "home",
+				grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+					request.End("Bye")
+					return false
+				}, "exit"),
+				grouter.NewMenuOption("2", func(request grouter.UssdRequest) bool {
+					panic("failure")
+				}, "broken"),
+			),
+		)
+	}
+	e := newTestEngine(t)
+	menu(e)
+	sim := newSimulator(t, e, "cleanup")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "END Bye")
+	if e.Storage.Get("cleanup") != nil {
+		t.Errorf("expected session to be removed")
+	}
+
+	// Gateways retrying the final request within the grace period get the
+	// same response
+	e = newTestEngine(t, grouter.WithEndGracePeriod(time.Hour), grouter.WithStorage(newJSONStorage()))
+	menu(e)
+	ended := 0
+	e.Hooks.SessionEnd = func(session grouter.UssdSession, reason grouter.SessionEndReason) {
+		ended++
+	}
+	sim = newSimulator(t, e, "cleanup-grace")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "END Bye")
+	if resp := sim.send(); resp != "END Bye\n" {
+		t.Errorf("expected the final response to be repeated, got %q", resp)
+	}
+	sim = newSimulator(t, e, "cleanup-error")
+	sim.expect("", "CON Welcome")
+	sim.expect("2", "END Session terminated due to internal error")
+	if resp := sim.send(); resp != "END Session terminated due to internal error\n" {
+		t.Errorf("expected the final response to be repeated, got %q", resp)
+	}
+	if ended != 2 {
+		t.Errorf("expected 2 sessions to end, got %d", ended)
+	}
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e.Storage.Get("cleanup-grace") != nil || e.Storage.Get("cleanup-error") != nil {
+		t.Errorf("expected sessions to be removed once the engine is closed")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	e := newTestEngine(t, grouter.WithStorage(newJSONStorage()), grouter.WithPagination(grouter.Pagination{
+		MaxLength: 36, MoreCode: "98", MoreLabel: "More", BackCode: "99", BackLabel: "Back",
+	}))
+	calls := 0
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+				calls++
+				request.Continue("Account")
+				return false
+			}, "account",
+				grouter.NewMenuOption("1", continueWith(grouter.NewLineStrings("Balance", "MWK 41,000.00", "MWK 9,000.00")), "balance"),
+			),
+		),
+	)
+	// The gateway retries requests whose response it did not receive
+	sim := newSimulator(t, e, "retry")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+	if resp := sim.send(); resp != "CON Account\n" {
+		t.Errorf("expected the last response to be repeated, got %q", resp)
+	}
+	sim.expect("1", "CON Balance")
+	page := sim.expect("98", "CON MWK 41,000.00")
+	if resp := sim.send(); resp != page {
+		t.Errorf("expected the last page to be repeated, got %q", resp)
+	}
+	sim.expect("98", "CON MWK 9,000.00")
+	if calls != 1 {
+		t.Errorf("expected the handler to be called once, got %d", calls)
+	}
+}
+
+// Storage buffering writes until flushed
+type flushingStorage struct {
+	grouter.Storage
+	flushed bool
+	closed  bool
+}
+
+func (s *flushingStorage) Flush(ctx context.Context) error {
+	s.flushed = true
+	return nil
+}
+
+func (s *flushingStorage) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	storage := &flushingStorage{Storage: newJSONStorage()}
+	e := newTestEngine(t,
+		grouter.WithStorage(storage),
+		grouter.WithShutdownMessage("Down for maintenance"),
+		grouter.WithEndGracePeriod(time.Hour),
+	)
+	var (
+		entered = make(chan struct{})
+		release = make(chan struct{})
+	)
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+				close(entered)
+				<-release
+				request.End("Done")
+				return false
+			}, "slow"),
+			grouter.NewMenuOption("2", func(request grouter.UssdRequest) bool {
+				request.End("Bye")
+				return false
+			}, "exit"),
+		),
+	)
+	sim := newSimulator(t, e, "close-ended")
+	sim.expect("", "CON Welcome")
+	sim.expect("2", "END Bye")
+	if e.Storage.Get("close-ended") == nil {
+		t.Fatalf("expected session to be kept during the grace period")
+	}
+
+	sim = newSimulator(t, e, "close")
+	sim.expect("", "CON Welcome")
+	done := make(chan string)
+	go func() { done <- sim.send("1") }()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := e.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected close to time out waiting for the in-flight request, got %v", err)
+	}
+	newSimulator(t, e, "close-new").expect("", "END Down for maintenance")
+
+	close(release)
+	if resp := <-done; resp != "END Done\n" {
+		t.Errorf("expected the in-flight request to complete, got %q", resp)
+	}
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !storage.flushed || !storage.closed {
+		t.Errorf("expected storage to be flushed and closed")
+	}
+	if e.Storage.Get("close-ended") != nil {
+		t.Errorf("expected session within its grace period to be removed")
+	}
+}
+
+// Storage that keeps sessions encoded, like a cache shared by several
+// replicas would
+type jsonStorage struct {
+	mu       sync.Mutex
+	sessions map[string][]byte
+}
+
+func newJSONStorage() *jsonStorage {
+	return &jsonStorage{sessions: map[string][]byte{}}
+}
+
+func (s *jsonStorage) Set(key string, session grouter.UssdSession) {
+	data, err := grouter.EncodeSession(session)
+	if err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[key] = data
+}
+
+func (s *jsonStorage) Get(key string) grouter.UssdSession {
+	s.mu.Lock()
+	data, ok := s.sessions[key]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	session, err := grouter.DecodeSession(data)
+	if err != nil {
+		panic(err)
+	}
+	return session
+}
+
+func (s *jsonStorage) Del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, key)
+}
+
+func (s *jsonStorage) Vacuum(duration time.Duration) {}
+
+func TestSharedStorage(t *testing.T) {
+	transfer := grouter.NewForm(
+		func(request grouter.UssdRequest, values grouter.FormValues) bool {
+			request.End("Sent %s to %s", values["amount"], values["account"])
+			return false
+		},
+		grouter.FormField{Key: "account", Prompt: "Enter account"},
+		grouter.FormField{Key: "amount", Prompt: "Enter amount"},
+	)
+	beneficiaries := func(request grouter.UssdRequest) []grouter.MenuItem {
+		return []grouter.MenuItem{{Label: "Alice", Value: "001"}, {Label: "Bob", Value: "002"}}
+	}
+	pay := func(request grouter.UssdRequest, item grouter.MenuItem) bool {
+		if request.Input() == "" {
+			request.Prompt("Amount for %s", item.Label)
+		} else {
+			request.End("Paid %s to %s", request.Input(), item.Value)
+		}
+		return true
+	}
+	statement := continueWith(grouter.NewLineStrings("Statement", "1. 24/07/31 5,000.00DR", "2. 24/03/01 9,000.00CR"))
+	storage := newJSONStorage()
+	replica := func() *grouter.Engine {
+		e := newTestEngine(t,
+			grouter.WithNavigationCodes("0", "00"),
+			grouter.WithInvalidOption("Invalid option, try again", 2),
+			grouter.WithPagination(grouter.Pagination{MaxLength: 50, MoreCode: "98", MoreLabel: "More", BackCode: "99", BackLabel: "Back"}),
+			grouter.WithStorage(storage),
+		)
+		e.MenuOptions(
+			grouter.NewMenuOption("", continueWith("Welcome"), "home",
+				grouter.NewMenuOption("1", transfer.Handler(), "transfer"),
+				grouter.NewDynamicMenuOption("2", "Pay to:", beneficiaries, pay, "beneficiaries"),
+				grouter.NewMenuOption("3", statement, "statement",
+					grouter.NewMenuOption("1", continueWith("Details"), "details"),
+				),
+			),
+		)
+		return e
+	}
+
+	// Requests alternate between replicas, as behind a load balancer, and
+	// every session goes through the encoding storage between requests
+	replicas := []*grouter.Engine{replica(), replica()}
+	type step struct{ input, prefix string }
+	sessions := map[string][]step{
+		"shared-form": {
+			{"", "CON Welcome"},
+			{"5", "CON Invalid option, try again\nWelcome"},
+			{"1", "CON Enter account"},
+			{"123", "CON Enter amount"},
+			{"#", "CON Enter account"},
+			{"456", "CON Enter amount"},
+			{"50", "END Sent 50 to 456"},
+		},
+		"shared-dynamic": {
+			{"", "CON Welcome"},
+			{"2", "CON Pay to:\n\n1. Alice\n2. Bob"},
+			{"2", "CON Amount for Bob"},
+			{"50", "END Paid 50 to 002"},
+		},
+		"shared-pages": {
+			{"", "CON Welcome"},
+			{"3", "CON Statement"},
+			{"98", "CON 1. 24/07/31"},
+			{"99", "CON Statement"},
+			{"1", "CON Details"},
+			{"0", "CON Statement"},
+			{"00", "CON Welcome"},
+			{"7", "CON Invalid option, try again\nWelcome"},
+			{"8", "END Invalid option"},
+		},
+	}
+	for id, steps := range sessions {
+		sim := newSimulator(t, nil, id)
+		for i, step := range steps {
+			sim.handler = replicas[i%len(replicas)]
+			sim.expect(step.input, step.prefix)
+		}
+	}
+}
+
+// Run with -race to check the engine for data races
+func TestConcurrentSessions(t *testing.T) {
+	e := newTestEngine(t, grouter.WithNavigationCodes("0", "00"))
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+				route := grouter.RouteFromContext(request.Context())
+				if route == nil || route.Name != "account" {
+					request.End("Unexpected route %v", route)
+					return false
+				}
+				request.Continue("Account %s", request.Session().ID())
+				return false
+			}, "account",
+				grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+					request.End("Balance %s", grouter.CurrentRoute(request).Name)
+					return false
+				}, "balance"),
+			),
+			grouter.NewMenuOption("2", func(request grouter.UssdRequest) bool {
+				panic("failure")
+			}, "broken"),
+		),
+	)
+	const sessions = 300
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		wg.Add(1)
+		go func(id string, broken bool) {
+			defer wg.Done()
+			sim := newSimulator(t, e, id)
+			steps := []struct{ input, expected string }{
+				{"", "CON Welcome\n"},
+				{"1", "CON Account " + id + "\n"},
+				{"0", "CON Welcome\n"},
+				{"1", "CON Account " + id + "\n"},
+				{"1", "END Balance balance\n"},
+			}
+			if broken {
+				steps = append(steps[:1], struct{ input, expected string }{"2", "END Session terminated due to internal error\n"})
+			}
+			for _, step := range steps {
+				var resp string
+				if step.input == "" {
+					resp = sim.send()
+				} else {
+					resp = sim.send(step.input)
+				}
+				if resp != step.expected {
+					t.Errorf("session %s, input %q: expected %q, got %q", id, step.input, step.expected, resp)
+					return
+				}
+			}
+		}(fmt.Sprintf("concurrent-%d", i), i%10 == 0)
+	}
+	wg.Wait()
+}
+
+func TestInvalidOption(t *testing.T) {
+	if _, err := grouter.NewRouterEngine(grouter.WithRouter(at.RouterName), grouter.WithInvalidOption("Invalid", 0)); err == nil {
+		t.Errorf("expected error for non-positive maximum attempts")
+	}
+	e := newTestEngine(t, grouter.WithInvalidOption("Invalid option, try again", 3))
+	calls := 0
+	account := func(request grouter.UssdRequest) bool {
+		calls++
+		request.Continue("Account\n1. Balance")
+		return false
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome\n1. Account"), "home",
+			grouter.NewMenuOption("1", account, "account",
+				grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+					request.End("Balance")
+					return false
+				}, "balance"),
+			).InvalidOption("Choose 1 to see your balance", 4),
+		),
+	)
+	sim := newSimulator(t, e, "invalid")
+	sim.expect("", "CON Welcome")
+	if resp := sim.send("5"); resp != "CON Invalid option, try again\nWelcome\n1. Account\n" {
+		t.Errorf("unexpected response %q", resp)
+	}
+	sim.expect("1", "CON Account")
+	for _, input := range []string{"7", "8"} {
+		if resp := sim.send(input); resp != "CON Choose 1 to see your balance\nAccount\n1. Balance\n" {
+			t.Errorf("unexpected response %q", resp)
+		}
+	}
+	sim.expect("9", "END Invalid option")
+	if calls != 1 {
+		t.Errorf("expected the screen handler to be called once, got %d", calls)
+	}
+
+	e = newTestEngine(t)
+	e.MenuOptions(grouter.NewMenuOption("", continueWith("Welcome"), "home",
+		grouter.NewMenuOption("1", continueWith("Account"), "account"),
+	))
+	sim = newSimulator(t, e, "invalid-default")
+	sim.expect("", "CON Welcome")
+	sim.expect("5", "END Invalid option")
+}
+
+func TestValidators(t *testing.T) {
+	e := newTestEngine(t)
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+				if request.Input() == "" {
+					request.Prompt("Enter amount")
+					return true
+				}
+				request.End("Sending %s", request.Input())
+				return false
+			}, "send").Validate(grouter.Amount(2)),
+			grouter.NewMenuOption("2", grouter.NewForm(func(request grouter.UssdRequest, values grouter.FormValues) bool {
+				request.End("PIN changed")
+				return false
+			}, grouter.FormField{Key: "pin", Prompt: "Enter new PIN", Validate: grouter.PIN(4)}).Handler(), "pin"),
+		),
+	)
+	sim := newSimulator(t, e, "validators")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Enter amount")
+	if resp := sim.send("abc"); resp != "CON Enter a valid amount\nEnter amount\n" {
+		t.Errorf("unexpected response %q", resp)
+	}
+	if resp := sim.send("12.505"); resp != "CON Enter a valid amount\nEnter amount\n" {
+		t.Errorf("unexpected response %q", resp)
+	}
+	sim.expect("12.50", "END Sending 12.50")
+
+	sim = newSimulator(t, e, "validators-form")
+	sim.expect("", "CON Welcome")
+	sim.expect("2", "CON Enter new PIN")
+	sim.expect("12a4", "CON Enter a 4 digit PIN")
+	sim.expect("1234", "END PIN changed")
+}
+
+func TestSessionExpiry(t *testing.T) {
+	menu := func(e *grouter.Engine) {
+		e.MenuOptions(
+			grouter.NewMenuOption("", continueWith("Welcome"), "home",
+				grouter.NewMenuOption("*", func(request grouter.UssdRequest) bool {
+					request.Continue("Echo %s", request.Option())
+					return true
+				}, "echo"),
+			),
+		)
+	}
+	// The sweeper is left idle and the storage is vacuumed directly
+	e := newTestEngine(t, grouter.WithSessionTimes(time.Hour, time.Hour))
+	menu(e)
+	sim := newSimulator(t, e, "expiry-idle")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Echo 1")
+	e.Storage.Vacuum(time.Hour)
+	if e.Storage.Get("expiry-idle") == nil {
+		t.Fatal("expected active session to be kept")
+	}
+	e.Storage.Vacuum(time.Nanosecond)
+	if e.Storage.Get("expiry-idle") != nil {
+		t.Errorf("expected idle session to be removed")
+	}
+
+	// An active session does not outlive the maximum lifetime
+	e = newTestEngine(t, grouter.WithSessionTimes(time.Hour, time.Hour), grouter.WithSessionLifetime(time.Nanosecond))
+	menu(e)
+	sim = newSimulator(t, e, "expiry")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Echo 1")
+	e.Storage.Vacuum(time.Hour)
+	if e.Storage.Get("expiry") != nil {
+		t.Errorf("expected session to be removed after its maximum lifetime")
+	}
+}
+
+func TestResume(t *testing.T) {
+	e := newTestEngine(t, grouter.WithResume(grouter.DefaultResume), grouter.WithStorage(newJSONStorage()))
+	var (
+		mu     sync.Mutex
+		events []string
+		calls  int
+	)
+	e.Hooks.SessionEnd = func(session grouter.UssdSession, reason grouter.SessionEndReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, session.ID()+":"+reason.String())
+	}
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+				calls++
+				request.Session().Set("account", "1001")
+				request.Continue("Account")
+				return false
+			}, "account",
+				grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+					request.End("Balance of %s", request.Session().MustGet("account"))
+					return false
+				}, "balance"),
+			),
+			grouter.NewMenuOption("2", grouter.NewForm(func(request grouter.UssdRequest, values grouter.FormValues) bool {
+				request.End("Sent %s", values["amount"])
+				return false
+			}, grouter.FormField{Key: "amount", Prompt: "Enter amount"}).Handler(), "transfer"),
+		),
+	)
+	// Dialling again without an interrupted session
+	sim := newSimulator(t, e, "resume-1")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+
+	// The network drops the session
+	sim = newSimulator(t, e, "resume-2")
+	sim.expect("", "CON 1. Continue where you left off")
+	sim.expect("3", "CON 1. Continue where you left off")
+	sim.expect("1", "CON Account")
+	sim.expect("1", "END Balance of 1001")
+	if e.Storage.Get("resume-1") != nil {
+		t.Errorf("expected the interrupted session to be removed")
+	}
+
+	sim = newSimulator(t, e, "resume-3")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim = newSimulator(t, e, "resume-4")
+	sim.expect("", "CON 1. Continue where you left off")
+	sim.expect("2", "CON Welcome")
+
+	// Another subscriber
+	sim = newSimulator(t, e, "resume-5")
+	sim.msisdn = "+265999000000"
+	sim.expect("", "CON Welcome")
+	// who only saw the index screen
+	sim = newSimulator(t, e, "resume-5a")
+	sim.msisdn = "+265999000000"
+	sim.expect("", "CON Welcome")
+
+	// Interrupted at a prompt, the session is only offered to be resumed on
+	// the same service code
+	sim = newSimulator(t, e, "resume-6")
+	sim.expect("", "CON Welcome")
+	sim.expect("2", "CON Enter amount")
+	sim = newSimulator(t, e, "resume-7")
+	sim.serviceCode = "*384*200#"
+	sim.expect("", "CON Welcome")
+	sim = newSimulator(t, e, "resume-8")
+	sim.expect("", "CON 1. Continue where you left off")
+	sim.expect("1", "CON Enter amount")
+	// A resumed session can be resumed again
+	sim = newSimulator(t, e, "resume-9")
+	sim.expect("", "CON 1. Continue where you left off")
+	sim.expect("1", "CON Enter amount")
+	sim.expect("50", "END Sent 50")
+
+	expected := []string{"resume-1:interrupted", "resume-2:ended", "resume-3:interrupted", "resume-6:interrupted", "resume-8:interrupted", "resume-9:ended"}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected events %v, expected %v", events, expected)
+	}
+	if calls != 2 {
+		t.Errorf("expected the account handler to be called twice, got %d", calls)
+	}
+}
+
+func TestResumeEndedSession(t *testing.T) {
+	// Ended sessions are kept in the shared storage during the grace period
+	e := newTestEngine(t,
+		grouter.WithResume(grouter.DefaultResume),
+		grouter.WithStorage(newJSONStorage()),
+		grouter.WithEndGracePeriod(time.Minute),
+	)
+	e.MenuOptions(
+		grouter.NewMenuOption("", continueWith("Welcome"), "home",
+			grouter.NewMenuOption("1", continueWith("Account"), "account",
+				grouter.NewMenuOption("1", func(request grouter.UssdRequest) bool {
+					request.End("Bye")
+					return false
+				}, "exit"),
+			),
+		),
+	)
+	sim := newSimulator(t, e, "resume-ended-1")
+	sim.expect("", "CON Welcome")
+	sim.expect("1", "CON Account")
+	sim.expect("1", "END Bye")
+	sim = newSimulator(t, e, "resume-ended-2")
+	sim.expect("", "CON Welcome")
+}
+
+func TestEndWithTemplate(t *testing.T) {
+	e := newTestEngine(t, grouter.WithTemplateFS(os.DirFS("./testdata/templates"), ".", template.FuncMap{}))
+	e.MenuOptions(grouter.NewMenuOption("", func(request grouter.UssdRequest) bool {
+		request.EndWithTemplate("main.tmpl", grouter.TemplateValues{"Phone": request.MSISDN()})
+		return false
+	}, "welcome"))
+	newSimulator(t, e, "end-template").expect("", "END Welcome +265888000000")
+}
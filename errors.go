@@ -4,4 +4,28 @@ import "fmt"
 
 var (
 	ErrRouterNotFound = fmt.Errorf("router not found")
+	// ErrStayOnScreen Returned by a RouteHandlerE to remain in the same screen
+	// context. It is not treated as a failure.
+	ErrStayOnScreen = fmt.Errorf("stay on screen")
+	// ErrDeferredNotFound Passed on to Engine.ErrorHandler when the operation
+	// started by Defer() for the session is not running in this process.
+	ErrDeferredNotFound = fmt.Errorf("deferred operation not found")
 )
+
+// PanicError A panic recovered from a handler, passed on to
+// Engine.ErrorHandler.
+type PanicError struct {
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap Returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
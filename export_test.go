@@ -0,0 +1,7 @@
+package grouter
+
+// OnRouted Sets a function called once the handlers of a request return, even
+// if the request was abandoned after a deadline.
+func OnRouted(e *Engine, f func()) {
+	e.routed = f
+}
@@ -0,0 +1,191 @@
+package grouter
+
+import (
+	"fmt"
+)
+
+// FormField A single step of a form.
+type FormField struct {
+	// Session key the value is stored under
+	Key string
+	// Prompt text. Ignored when Template is set
+	Prompt string
+	// Template used to render the prompt. The values collected so far are
+	// passed to the template, along with the validation error as `Error`
+	Template string
+	// Optional input validator, see the built-in validators such as
+	// Numeric(). The error message is shown above the prompt when the input
+	// is rejected
+	Validate Validator
+}
+
+// FormValues Values collected by a form, keyed by FormField.Key
+type FormValues map[string]string
+
+// FormSubmitHandler Handles a completed form. The return value has the same
+// meaning as that of RouteHandler.
+type FormSubmitHandler func(request UssdRequest, values FormValues) bool
+
+// Form A multi-step form that prompts for each field in turn.
+//
+// The form takes care of re-prompting on invalid input, going back to the
+// previous field, and the optional confirmation step. Entered values are
+// stored in the session under the field keys.
+type Form struct {
+	fields          []FormField
+	submit          FormSubmitHandler
+	confirmTemplate string
+	confirmText     func(values FormValues) string
+	confirmCode     string
+	cancelCode      string
+	backCode        string
+	cancelText      string
+}
+
+// NewForm Creates a form that calls the submit handler once all the fields
+// have been entered (and confirmed, if confirmation is enabled).
+//
+// By default `#` goes back to the previous field, and at the confirmation
+// step `1` submits the form while `2` cancels it.
+func NewForm(submit FormSubmitHandler, fields ...FormField) *Form {
+	if submit == nil || len(fields) == 0 {
+		panic(fmt.Errorf("form: submit handler and fields are required"))
+	}
+	return &Form{
+		fields:      fields,
+		submit:      submit,
+		confirmCode: "1",
+		cancelCode:  "2",
+		backCode:    "#",
+		cancelText:  "Cancelled",
+	}
+}
+
+// Confirm Adds a confirmation step rendered using the given template. The
+// template receives the form values.
+func (f *Form) Confirm(tmplName string) *Form {
+	f.confirmTemplate = tmplName
+	return f
+}
+
+// ConfirmText Adds a confirmation step rendered using the given function.
+func (f *Form) ConfirmText(render func(values FormValues) string) *Form {
+	f.confirmText = render
+	return f
+}
+
+// Codes Sets the codes used to confirm, cancel and go back.
+func (f *Form) Codes(confirm, cancel, back string) *Form {
+	f.confirmCode = confirm
+	f.cancelCode = cancel
+	f.backCode = back
+	return f
+}
+
+// Cancelled Sets the text the session ends with when the form is cancelled.
+func (f *Form) Cancelled(text string) *Form {
+	f.cancelText = text
+	return f
+}
+
+func (f *Form) hasConfirmation() bool {
+	return f.confirmTemplate != "" || f.confirmText != nil
+}
+
+// Handler Returns the route handler that runs the form.
+func (f *Form) Handler() RouteHandler {
+	return func(request UssdRequest) bool {
+		var (
+			session = request.Session()
+			key     = f.stepKey(request)
+			input   = request.Input()
+			step    int
+		)
+		LoadSessionValue(session, key, &step)
+		switch {
+		case input == "":
+			// entering the form
+			step = 0
+		case input == f.backCode:
+			step = max(step-1, 0)
+		case step < len(f.fields):
+			field := f.fields[step]
+			if field.Validate != nil {
+				if err := field.Validate(input); err != nil {
+					f.prompt(request, step, err.Error())
+					return true
+				}
+			}
+			session.Set(field.Key, input)
+			step++
+			if step == len(f.fields) && !f.hasConfirmation() {
+				session.Del(key)
+				return f.submit(request, f.values(request))
+			}
+		case input == f.confirmCode:
+			session.Del(key)
+			return f.submit(request, f.values(request))
+		case input == f.cancelCode:
+			session.Del(key)
+			request.End(f.cancelText)
+			return false
+		}
+		session.Set(key, step)
+		f.prompt(request, step, "")
+		return true
+	}
+}
+
+func (f *Form) stepKey(request UssdRequest) string {
+	name := ""
+	if route := CurrentRoute(request); route != nil {
+		name = route.Name
+	}
+	return "grouter.form." + name
+}
+
+func (f *Form) values(request UssdRequest) FormValues {
+	values := FormValues{}
+	for _, field := range f.fields {
+		if value, ok := request.Session().Get(field.Key); ok {
+			values[field.Key], _ = value.(string)
+		}
+	}
+	return values
+}
+
+func (f *Form) prompt(request UssdRequest, step int, errText string) {
+	values := f.values(request)
+	if step < len(f.fields) {
+		field := f.fields[step]
+		if field.Template != "" {
+			request.PromptWithTemplate(field.Template, templateValues(values, errText))
+		} else {
+			request.Prompt("%s", NewLineStrings(nonEmpty(errText, field.Prompt)...))
+		}
+		return
+	}
+	if f.confirmTemplate != "" {
+		request.PromptWithTemplate(f.confirmTemplate, templateValues(values, errText))
+	} else {
+		request.Prompt("%s", f.confirmText(values))
+	}
+}
+
+func templateValues(values FormValues, errText string) TemplateValues {
+	tv := TemplateValues{"Error": errText}
+	for k, v := range values {
+		tv[k] = v
+	}
+	return tv
+}
+
+func nonEmpty(text ...string) []string {
+	var result []string
+	for _, t := range text {
+		if !IsEmptyText(t) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
@@ -2,4 +2,7 @@ module github.com/SharkFourSix/grouter
 
 go 1.21.5
 
-require github.com/orcaman/concurrent-map v1.0.0
+require (
+	github.com/orcaman/concurrent-map v1.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,200 @@
+package grouter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// GraphFormat Output format of menu diagrams.
+type GraphFormat int
+
+const (
+	// Graphviz DOT
+	GraphDOT GraphFormat = iota
+	// Mermaid flowchart
+	GraphMermaid
+)
+
+// ParseGraphFormat Parses a graph format name, either `dot` or `mermaid`.
+func ParseGraphFormat(name string) (GraphFormat, error) {
+	switch strings.ToLower(name) {
+	case "dot":
+		return GraphDOT, nil
+	case "mermaid":
+		return GraphMermaid, nil
+	}
+	return 0, fmt.Errorf("unsupported graph format `%s`", name)
+}
+
+type graphNode struct {
+	id      string
+	name    string
+	handler string
+	any     bool // source of global options
+}
+
+type graphEdge struct {
+	from, to *graphNode
+	label    string
+}
+
+type menuGraph struct {
+	nodes []*graphNode
+	edges []graphEdge
+}
+
+func newMenuGraph(nodes []menuNode) *menuGraph {
+	var (
+		g       = &menuGraph{}
+		byName  = map[string]*graphNode{}
+		visited = map[graphEdge]bool{}
+	)
+	node := func(name string) *graphNode {
+		if n, ok := byName[name]; ok {
+			return n
+		}
+		n := &graphNode{id: fmt.Sprintf("n%d", len(g.nodes)), name: name}
+		byName[name] = n
+		g.nodes = append(g.nodes, n)
+		return n
+	}
+	var (
+		start     = node("")
+		anyScreen *graphNode
+	)
+	for _, mn := range nodes {
+		from := start
+		switch {
+		case mn.screen != "":
+			from = node(mn.screen)
+		case mn.opt.global:
+			if anyScreen == nil {
+				anyScreen = &graphNode{id: fmt.Sprintf("n%d", len(g.nodes)), any: true}
+				g.nodes = append(g.nodes, anyScreen)
+			}
+			from = anyScreen
+		}
+		to := node(mn.opt.name)
+		if to.handler == "" {
+			to.handler = handlerName(mn.opt)
+		}
+		edge := graphEdge{from: from, to: to, label: edgeLabel(mn.opt)}
+		if !visited[edge] {
+			visited[edge] = true
+			g.edges = append(g.edges, edge)
+		}
+	}
+	return g
+}
+
+func handlerName(opt *MenuOption) string {
+	if opt.handlerName != "" {
+		return opt.handlerName
+	}
+	if opt.handler == nil {
+		return "<nil>"
+	}
+	name := "<unknown>"
+	if fn := runtime.FuncForPC(opt.handlerID); fn != nil {
+		name = fn.Name()
+	}
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func edgeLabel(opt *MenuOption) string {
+	label := opt.code
+	if label == "" {
+		label = "dial"
+	}
+	if opt.label != "" {
+		label += ". " + opt.label
+	}
+	return label
+}
+
+func (g *menuGraph) write(w io.Writer, format GraphFormat) error {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case GraphDOT:
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+		fmt.Fprintln(bw, "digraph menu {")
+		for _, n := range g.nodes {
+			switch {
+			case n.any:
+				fmt.Fprintf(bw, "\t%s [label=\"any screen\", shape=doublecircle];\n", n.id)
+			case n.name == "":
+				fmt.Fprintf(bw, "\t%s [label=\"start\", shape=circle];\n", n.id)
+			default:
+				fmt.Fprintf(bw, "\t%s [label=\"%s\\n%s\", shape=box];\n", n.id, quote(n.name), quote(n.handler))
+			}
+		}
+		for _, e := range g.edges {
+			fmt.Fprintf(bw, "\t%s -> %s [label=\"%s\"];\n", e.from.id, e.to.id, quote(e.label))
+		}
+		fmt.Fprintln(bw, "}")
+	case GraphMermaid:
+		quote := strings.NewReplacer(`"`, "#quot;", "\n", "<br/>").Replace
+		fmt.Fprintln(bw, "flowchart TD")
+		for _, n := range g.nodes {
+			switch {
+			case n.any:
+				fmt.Fprintf(bw, "\t%s(((\"any screen\")))\n", n.id)
+			case n.name == "":
+				fmt.Fprintf(bw, "\t%s((\"start\"))\n", n.id)
+			default:
+				fmt.Fprintf(bw, "\t%s[\"%s<br/><i>%s</i>\"]\n", n.id, quote(n.name), quote(n.handler))
+			}
+		}
+		for _, e := range g.edges {
+			fmt.Fprintf(bw, "\t%s -->|\"%s\"| %s\n", e.from.id, quote(e.label), e.to.id)
+		}
+	default:
+		return fmt.Errorf("unsupported graph format %d", format)
+	}
+	return bw.Flush()
+}
+
+// WriteGraph Writes a diagram of the registered menu options. Screens are
+// drawn as nodes labelled with the option name and handler, and options as
+// edges labelled with their codes (and labels, for options loaded from menu
+// specs).
+//
+// This is the fallback menu tree; use Engine.WriteServiceGraph() for the menu
+// tree of a service code.
+func (e *Engine) WriteGraph(w io.Writer, format GraphFormat) error {
+	return e.menu.writeGraph(w, format)
+}
+
+// WriteServiceGraph Writes a diagram of the menu options registered for the
+// service code. See Engine.WriteGraph().
+func (e *Engine) WriteServiceGraph(w io.Writer, format GraphFormat, serviceCode string) error {
+	m, ok := e.serviceMenus[serviceCode]
+	if !ok {
+		return fmt.Errorf("no menu registered for service code `%s`", serviceCode)
+	}
+	return m.writeGraph(w, format)
+}
+
+func (m *menuTree) writeGraph(w io.Writer, format GraphFormat) error {
+	nodes := make([]menuNode, len(m.options))
+	for i, opt := range m.options {
+		nodes[i] = menuNode{opt: opt, screen: opt.parentScreen}
+	}
+	return newMenuGraph(nodes).write(w, format)
+}
+
+// WriteMenuGraph Writes a diagram of a menu tree without registering it. See
+// Engine.WriteGraph().
+func WriteMenuGraph(w io.Writer, format GraphFormat, opts ...*MenuOption) error {
+	var nodes []menuNode
+	for _, opt := range opts {
+		nodes = flattenMenu(nodes, opt, "")
+	}
+	return newMenuGraph(nodes).write(w, format)
+}
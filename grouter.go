@@ -1,14 +1,15 @@
 package grouter
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
 	"path"
-	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 )
@@ -22,32 +23,69 @@ type Engine struct {
 	Log              Logger
 	Debug            bool
 	NotFound         RouteHandler
+	ErrorHandler     ErrorHandler
+	Hooks            Hooks
 	router           UssdRouter
-	options          []*MenuOption
+	menu             *menuTree
+	serviceMenus     map[string]*menuTree
+	globals          []*MenuOption
 	Storage          Storage
-	ihandler         int
+	middleware       []Middleware
 	templateMap      map[string]*template.Template
-	stateCache       *stateCache
-	indexScreen      string
 	storageFrequency time.Duration
 	storageEviction  time.Duration
-}
-
-func (e Engine) currentHandler() string {
-	if e.ihandler >= 0 {
-		h := e.options[e.ihandler]
-		return fmt.Sprintf("handler(options=%s,name=%s,ptr=%v)", h.code, h.name, h.handler)
-	} else {
-		return "handler(option=,name=)"
-	}
+	storageLifetime  time.Duration
+	backCode         string
+	homeCode         string
+	pagination       *Pagination
+	invalidOption    *InvalidOption
+	resume           *Resume
+	responseBudget   time.Duration
+	deadlineText     string
+	endGracePeriod   time.Duration
+	shutdownText     string
+	lifecycle        sync.Mutex
+	closed           bool
+	closeOnce        sync.Once
+	inflight         sync.WaitGroup
+	pendingDrops     map[*time.Timer]func()
+	deferred         deferredOps
+	routed           func() // called once a request is routed, for tests
 }
 
 type MenuOption struct {
 	code         string
+	matcher      codeMatcher
 	handler      RouteHandler
 	name         string
 	sub          []*MenuOption
 	parentScreen string
+	middleware   []Middleware
+	label        string
+	handlerName  string
+	handlerID    uintptr
+	global       bool
+	timeout      time.Duration
+	invalid      *InvalidOption
+	validators   []Validator
+}
+
+// Use Adds middleware that only wraps the handler of this option.
+//
+// Option middleware runs after the engine middleware registered through
+// Engine.Use.
+func (o *MenuOption) Use(middleware ...Middleware) *MenuOption {
+	o.middleware = append(o.middleware, middleware...)
+	return o
+}
+
+// Timeout Sets the time the handler of this option has to respond. The
+// handler can observe the deadline through UssdRequest.Context(). When the
+// deadline passes, the engine sends the fallback response configured through
+// WithResponseDeadline() instead of waiting for the handler.
+func (o *MenuOption) Timeout(d time.Duration) *MenuOption {
+	o.timeout = d
+	return o
 }
 
 // Responsible for creating USSD requests
@@ -68,13 +106,29 @@ type UssdRouter interface {
 	CreateRequest(resp *BufferedResponse, req *http.Request, storage Storage) (UssdRequest, error)
 }
 
+// TransientKeyRouter Implemented by routers that keep session values which
+// only apply to the requests of a single session, such as the position read up
+// to in the text of the request. These values are not carried over when an
+// interrupted session is resumed.
+type TransientKeyRouter interface {
+	TransientSessionKeys() []string
+}
+
 // USSD Request handler. Return true to remain in the same screen context
 // or false to indicate to the routing engine to advance the context.
 //
 // A screen context confines menu options to a set, allowing the same option
 // values to be used without conflicts.
+//
+// Handlers that need finer control over the routing state can return an
+// Outcome instead. See OutcomeHandler.
 type RouteHandler func(request UssdRequest) bool
 
+// Middleware wraps a route handler. Middleware can inspect the matched route
+// using CurrentRoute(), or stop the request by writing its own response
+// (request.End(), request.Continue(), etc) without calling the next handler.
+type Middleware func(next RouteHandler) RouteHandler
+
 type RouterOption func(r *Engine) error
 
 func NewRouterEngine(options ...RouterOption) (*Engine, error) {
@@ -86,44 +140,334 @@ func NewRouterEngine(options ...RouterOption) (*Engine, error) {
 			request.End("Invalid option")
 			return false
 		},
-		stateCache:  newStateCache(30*time.Second, 2*time.Minute),
-		templateMap: map[string]*template.Template{},
+		ErrorHandler: func(request UssdRequest, err error) Outcome {
+			request.End("Session terminated due to internal error")
+			return EndSession
+		},
+		deadlineText: "Request timed out. Please try again later",
+		shutdownText: "Service unavailable. Please try again later",
+		templateMap:  map[string]*template.Template{},
+		menu:         &menuTree{},
+		serviceMenus: map[string]*menuTree{},
 	}
 	for _, opt := range options {
 		if err := opt(&r); err != nil {
 			return nil, err
 		}
 	}
-	r.Storage = NewInMemorySessionStorage(r.storageFrequency, r.storageEviction)
+	if r.Storage == nil {
+		r.Storage = NewInMemorySessionStorageWithExpiry(r.storageFrequency, SessionExpiry{
+			Idle:        r.storageEviction,
+			MaxLifetime: r.storageLifetime,
+		})
+	}
+	if notifier, ok := r.Storage.(EvictionNotifier); ok {
+		notifier.OnEvict(func(session UssdSession) {
+			r.sessionEnded(session, SessionEvicted)
+		})
+	}
 	if r.router == nil {
 		return nil, ErrRouterNotFound
 	}
 	return &r, nil
 }
 
-func (e *Engine) mapOption(opt *MenuOption, parent *MenuOption) {
-	if opt != nil {
-		if parent != nil {
-			opt.parentScreen = parent.name
-		}
-		e.options = append(e.options, opt)
-		for _, subOpt := range opt.sub {
-			e.mapOption(subOpt, opt)
+func (e *Engine) MenuOptions(opts ...*MenuOption) {
+	e.menu.add(opts...)
+}
+
+// ServiceMenu Registers a menu tree that is served for requests to the given
+// service code, e.g. `*384*100#`. The tree has its own index screen. Requests
+// to service codes without a menu tree are served by the tree registered
+// through Engine.MenuOptions().
+func (e *Engine) ServiceMenu(serviceCode string, opts ...*MenuOption) {
+	m, ok := e.serviceMenus[serviceCode]
+	if !ok {
+		m = &menuTree{}
+		for _, opt := range e.globals {
+			m.mapOption(opt, nil)
 		}
+		e.serviceMenus[serviceCode] = m
 	}
+	m.add(opts...)
 }
 
-func (e *Engine) MenuOptions(opts ...*MenuOption) {
-	// create map
+// GlobalOptions Registers options that are available on every screen,
+// including the index screen, such as "99" for the main menu. They are only
+// skipped on the first request of a session, when the user dials the service
+// code. Global options are matched before the options of the current
+// screen, unless the screen has an option with the same code, which then
+// takes precedence.
+//
+// Like navigation codes, global options only apply when reading options, not
+// input entered at a prompt. Note that the code "*" is a wildcard; use the
+// pattern `/^\*$/` to match a literal star.
+//
+// The sub options of a global option form the screen shown after it, as with
+// any other option.
+//
+// Global options apply to all menu trees, including service code trees.
+func (e *Engine) GlobalOptions(opts ...*MenuOption) {
 	for _, opt := range opts {
-		if opt.code == "" {
-			if e.indexScreen != "" {
-				panic(fmt.Errorf("index screen is already set to `%s`", e.indexScreen))
-			} else {
-				e.indexScreen = opt.name
+		opt.global = true
+		e.globals = append(e.globals, opt)
+		e.menu.mapOption(opt, nil)
+		for _, m := range e.serviceMenus {
+			m.mapOption(opt, nil)
+		}
+	}
+}
+
+// RegisterMenuOptions Validates the menu tree using ValidateMenu() and then
+// registers it. Nothing is registered if the tree is invalid.
+func (e *Engine) RegisterMenuOptions(opts ...*MenuOption) error {
+	if err := ValidateMenu(opts...); err != nil {
+		return err
+	}
+	if e.menu.indexScreen != "" {
+		return fmt.Errorf("index screen is already set to `%s`", e.menu.indexScreen)
+	}
+	e.MenuOptions(opts...)
+	return nil
+}
+
+// RegisterServiceMenu Validates the menu tree using ValidateMenu() and then
+// registers it for the service code. See Engine.ServiceMenu().
+func (e *Engine) RegisterServiceMenu(serviceCode string, opts ...*MenuOption) error {
+	if err := ValidateMenu(opts...); err != nil {
+		return err
+	}
+	if m, ok := e.serviceMenus[serviceCode]; ok && m.indexScreen != "" {
+		return fmt.Errorf("%s: index screen is already set to `%s`", serviceCode, m.indexScreen)
+	}
+	e.ServiceMenu(serviceCode, opts...)
+	return nil
+}
+
+// Returns the menu tree that serves the request
+func (e *Engine) menuFor(request UssdRequest) *menuTree {
+	if m, ok := e.serviceMenus[request.ServiceCode()]; ok {
+		return m
+	}
+	return e.menu
+}
+
+// Use Adds middleware that wraps the handlers of all menu options.
+//
+// Middleware is applied in the order it was added, the first one being the
+// outermost.
+func (e *Engine) Use(middleware ...Middleware) {
+	e.middleware = append(e.middleware, middleware...)
+}
+
+// State of a single request as it is routed through the engine
+type routing struct {
+	request UssdRequest
+	writer  *BufferedResponse
+	menu    *menuTree
+	hops    int // handlers invoked so far
+	watch   *deadlineWatch
+	paged   bool // the response is a page of a previous response
+	// Option whose handler was invoked last. It is read when the handler is
+	// abandoned after a deadline, while the handler goroutine still runs.
+	current atomic.Pointer[MenuOption]
+	// Set when the response has been sent without waiting for the handler
+	abandoned atomic.Bool
+}
+
+// Reports whether the request no longer updates the routing state of the
+// session, having been abandoned after a deadline or the session having ended.
+// A passed deadline counts as well, since the request is abandoned as soon as
+// the engine notices it.
+func (rc *routing) detached() bool {
+	if rc.abandoned.Load() || rc.watch.exceeded() {
+		return true
+	}
+	_, ended := rc.request.Session().Get(sessionEndedKey)
+	return ended
+}
+
+func (rc *routing) currentHandler() string {
+	if h := rc.current.Load(); h != nil {
+		return fmt.Sprintf("handler(options=%s,name=%s,ptr=%v)", h.code, h.name, h.handler)
+	} else {
+		return "handler(option=,name=)"
+	}
+}
+
+// Invokes the handler of the option, or the given handler in its place, and
+// returns its outcome
+func (e *Engine) invoke(rc *routing, index int, screen string, h RouteHandler) Outcome {
+	opt := rc.menu.options[index]
+	rc.current.Store(opt)
+	route := &Route{Screen: screen, Name: opt.name, Code: opt.code}
+	rc.request.SetAttribute(routeAttribute, route)
+	if h == nil {
+		h = opt.handler
+	}
+	for i := len(opt.middleware) - 1; i >= 0; i-- {
+		h = opt.middleware[i](h)
+	}
+	for i := len(e.middleware) - 1; i >= 0; i-- {
+		h = e.middleware[i](h)
+	}
+	ctx := context.WithValue(rc.request.Context(), routeContextKey{}, route)
+	if opt.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.timeout)
+		defer cancel()
+		rc.watch.watch(ctx)
+	}
+	request := &contextRequest{UssdRequest: rc.request, ctx: ctx}
+	if err := opt.validate(request); err != nil {
+		e.Log.Printf("invalid input: %v. handler info : %s", err, rc.currentHandler())
+		e.reprompt(rc, err)
+		return Stay
+	}
+	stay, err := e.call(h, request)
+	if err == nil {
+		err = route.err
+	}
+	if err != nil {
+		e.Log.Printf("error: %v. handler info : %s", err, rc.currentHandler())
+		rc.writer.reset()
+		return e.ErrorHandler(rc.request, err)
+	}
+	switch {
+	case rc.writer.redirect != "":
+		return Redirect(rc.writer.redirect)
+	case route.outcome != nil:
+		return *route.outcome
+	case stay:
+		return Stay
+	}
+	return Advance
+}
+
+// Calls the handler, recovering panics as errors
+func (e *Engine) call(h RouteHandler, request UssdRequest) (stay bool, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Value: p}
+		}
+	}()
+	return h(request), nil
+}
+
+// Maximum number of handlers invoked within a single request, through
+// redirects or Back and Home outcomes
+const maxRedirects = 8
+
+// Invokes the handler and updates the routing state according to the outcome.
+//
+// If the handler redirects to another screen, the handler of that screen is
+// invoked instead and the outcome of the redirecting handler is ignored.
+func (e *Engine) show(rc *routing, index int, screen string) {
+	e.showHandler(rc, index, screen, nil)
+}
+
+// Same as show(), running the given handler in place of the handler of the
+// option. Redirects run the handlers of their screens.
+func (e *Engine) showHandler(rc *routing, index int, screen string, h RouteHandler) {
+	visited := map[string]bool{rc.menu.options[index].name: true}
+	for {
+		rc.hops++
+		if rc.hops > maxRedirects {
+			panic(fmt.Errorf("%s: too many redirects", rc.currentHandler()))
+		}
+		outcome := e.invoke(rc, index, screen, h)
+		h = nil
+		if rc.detached() {
+			return
+		}
+		session := rc.request.Session()
+		switch outcome.kind {
+		case stayOutcome:
+		case advanceOutcome:
+			pushScreen(session, rc.menu.options[index].name)
+		case backOutcome:
+			rc.writer.reset()
+			e.back(rc)
+		case homeOutcome:
+			rc.writer.reset()
+			e.home(rc)
+		case endOutcome:
+			resetScreens(session)
+		case redirectOutcome:
+			target := outcome.screen
+			rc.writer.reset()
+			if visited[target] {
+				panic(fmt.Errorf("%s: redirect loop detected at screen `%s`", rc.currentHandler(), target))
+			}
+			visited[target] = true
+			if index = rc.menu.optionByName(target); index == -1 {
+				panic(fmt.Errorf("%s: redirect to unknown screen `%s`", rc.currentHandler(), target))
 			}
+			e.Log.Printf("redirect=%s", target)
+			screen = rc.menu.options[index].parentScreen
+			continue
+		}
+		return
+	}
+}
+
+// Navigation codes are only honoured when reading options. Input entered at a
+// prompt is always passed on to the handler.
+func (e *Engine) isNavigation(request UssdRequest, code string) bool {
+	return code != "" && request.Input() == "" && request.Option() == code
+}
+
+// Re-renders the previous screen in the navigation history
+func (e *Engine) back(rc *routing) {
+	previous := popScreen(rc.request.Session())
+	e.Log.Printf("navigation=back, screen=%s", previous)
+	if previous == "" {
+		e.home(rc)
+		return
+	}
+	if index := rc.menu.optionByName(previous); index != -1 {
+		e.show(rc, index, rc.menu.options[index].parentScreen)
+	} else {
+		e.NotFound(rc.request)
+	}
+}
+
+// Re-renders the index screen and clears the navigation history
+func (e *Engine) home(rc *routing) {
+	resetScreens(rc.request.Session())
+	e.Log.Printf("navigation=home, screen=%s", rc.menu.indexScreen)
+	if index := rc.menu.optionByName(rc.menu.indexScreen); rc.menu.indexScreen != "" && index != -1 {
+		e.show(rc, index, "")
+	} else {
+		e.NotFound(rc.request)
+	}
+}
+
+// Routes the request to the handler of the matching option
+func (e *Engine) route(rc *routing) {
+	request := rc.request
+	// get current screen
+	screen := currentScreen(request.Session())
+	switch {
+	case e.isNavigation(request, e.backCode):
+		e.back(rc)
+	case e.isNavigation(request, e.homeCode):
+		e.home(rc)
+	default:
+		index := -1
+		if screen != "" && request.Input() == "" {
+			index = matchGlobal(rc.menu.options, screen, request.Option())
+		}
+		if index == -1 {
+			index = matchOption(rc.menu.options, screen, request.Option())
+		}
+		e.Log.Printf("screen=%s, index=%d, option=%s, input=%s", screen, index, request.Option(), request.Input())
+		if index != -1 {
+			e.Log.Printf("matched-handler=%s", rc.menu.options[index].name)
+			e.show(rc, index, screen)
+		} else {
+			e.notFound(rc, screen)
 		}
-		e.mapOption(opt, nil)
 	}
 }
 
@@ -132,46 +476,103 @@ func (e *Engine) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 func (e *Engine) RouteFromHttpRequest(w http.ResponseWriter, req *http.Request) {
+	var (
+		writer  BufferedResponse
+		request UssdRequest
+		err     error
+		rc      = &routing{writer: &writer}
+	)
 	end := func(text string) {
+		response := fmt.Sprintf("END %s\n", text)
+		if request != nil {
+			e.keepEndResponse(request.Session(), response)
+		}
 		w.WriteHeader(200)
-		_, _ = fmt.Fprintf(w, "END %s\n", text)
+		_, _ = io.WriteString(w, response)
+		if request != nil {
+			e.sessionEnded(request.Session(), SessionEnded)
+		}
 	}
+	if !e.acquire() {
+		end(e.shutdownText)
+		return
+	}
+	defer e.inflight.Done()
 	defer func() {
 		if p := recover(); p != nil {
-			e.Log.Printf("error: %v. handler info : %s", p, e.currentHandler())
+			e.Log.Printf("error: %v. handler info : %s", p, rc.currentHandler())
 			end("Session terminated due to internal error")
 		}
 	}()
-	var writer BufferedResponse
-	request, err := e.router.CreateRequest(&writer, req, e.Storage)
+	rc.watch = newDeadlineWatch()
+	if e.responseBudget > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), e.responseBudget)
+		defer cancel()
+		rc.watch.watch(ctx)
+		req = req.WithContext(ctx)
+	}
+	request, err = e.router.CreateRequest(&writer, req, e.Storage)
 	if err != nil {
 		e.Log.Printf("error creating request: %v", err)
 		end("Session closed")
 		return
 	} else {
-		// get current screen
-		screen, _ := e.stateCache.get(request.Session().ID())
-		index := slices.IndexFunc(e.options, func(mo *MenuOption) bool {
-			return mo.code == request.Option() && mo.parentScreen == screen
-		})
-		e.Log.Printf("screen=%s, index=%d, option=%s, input=%s", screen, index, request.Option(), request.Input())
-		if index != -1 {
-			e.ihandler = index
-			opt := e.options[index]
-			e.Log.Printf("matched-handler=%s", opt.name)
-			if !opt.handler(request) {
-				e.stateCache.set(request.Session().ID(), opt.name)
+		// Handlers run in a separate goroutine so that the fallback response
+		// can be sent when a deadline passes. The handler is abandoned, and
+		// whatever it writes afterwards is discarded.
+		var (
+			done     = make(chan struct{})
+			panicked any
+			screen   string
+		)
+		if e.replay(w, request) {
+			return
+		}
+		request.SetAttribute(engineAttribute, e)
+		rc.request = request
+		rc.menu = e.menuFor(request)
+		request.Session().Touch()
+		go func() {
+			defer func() {
+				panicked = recover()
+				close(done)
+				if e.routed != nil {
+					e.routed()
+				}
+			}()
+			first := e.sessionStarted(request)
+			screen = currentScreen(request.Session())
+			if !e.resumeJourney(rc, first) && !e.resumeDeferred(rc) {
+				if rc.paged = e.turnPage(request, &writer); !rc.paged {
+					e.route(rc)
+				}
 			}
-		} else {
-			e.NotFound(request)
+		}()
+		select {
+		case <-done:
+		case <-rc.watch.expired:
+		}
+		// A handler that returns as its deadline passes gets the fallback
+		// response as well
+		if rc.watch.exceeded() {
+			rc.abandoned.Store(true)
+			e.Log.Printf("deadline exceeded. handler info : %s", rc.currentHandler())
+			end(e.deadlineText)
+			return
 		}
+		if panicked != nil {
+			panic(panicked)
+		}
+		// Screen hooks fire here rather than in the handler goroutine, so that
+		// they never follow the end of a session whose request was abandoned
+		e.screenChanged(request, screen)
 		if writer.buf.Len() == 0 && IsEmptyText(writer.templateName) {
-			e.Log.Printf("session ended because there was no response from handler `%s`. Make sure to call request.EndXXX or ContinueXXX", e.currentHandler())
+			e.Log.Printf("session ended because there was no response from handler `%s`. Make sure to call request.EndXXX or ContinueXXX", rc.currentHandler())
 			end("Unexpected end of session")
 		} else {
 			if !IsEmptyText(writer.templateName) {
 				if tmpl, ok := e.templateMap[writer.templateName]; !ok {
-					panic(fmt.Errorf("%s: template not found `%s`", e.currentHandler(), writer.templateName))
+					panic(fmt.Errorf("%s: template not found `%s`", rc.currentHandler(), writer.templateName))
 				} else {
 					if writer.end {
 						writer.Printf("END ")
@@ -185,22 +586,76 @@ func (e *Engine) RouteFromHttpRequest(w http.ResponseWriter, req *http.Request)
 					}
 				}
 			}
+			if rc.current.Load() != nil && strings.HasPrefix(writer.buf.String(), continuePrefix) {
+				request.Session().Set(lastResponseKey, writer.buf.String())
+			}
+			writer.insertBanner()
+			if !rc.paged {
+				e.paginate(request, &writer)
+			}
+			request.Session().Set(sentResponseKey, writer.buf.String())
+			if strings.HasPrefix(writer.buf.String(), "END ") {
+				request.Session().Set(endResponseKey, writer.buf.String())
+			}
+			// Save the session along with the routing state before responding,
+			// so that the next request can be handled by any replica sharing
+			// the storage. A session evicted while the request was handled
+			// stays evicted.
+			if _, ended := request.Session().Get(sessionEndedKey); !ended {
+				e.rememberSession(rc)
+				e.Storage.Set(request.Session().ID(), request.Session())
+			}
 			_, err = w.Write(writer.buf.Bytes())
 			if err != nil {
 				e.Log.Printf(err.Error())
 			}
+			if strings.HasPrefix(writer.buf.String(), "END ") {
+				e.sessionEnded(request.Session(), SessionEnded)
+			}
 		}
 	}
 }
 
+// NewMenuOption Creates a menu option matching the given code.
+//
+// Besides literal values, the code can be one of the following:
+//
+//	"*"        matches any input
+//	"1-9"      matches any integer in the (inclusive) range
+//	"/^\d+$/"  matches the input against a regular expression
+//
+// When several options in the same screen match, exact codes take precedence,
+// followed by ranges, patterns and finally wildcards. The handler can use
+// UssdRequest.Option() to get the actual value entered.
 func NewMenuOption(code string, h RouteHandler, name string, sub ...*MenuOption) *MenuOption {
+	opt, err := newMenuOption(code, h, name, sub...)
+	if err != nil {
+		panic(err)
+	}
+	return opt
+}
+
+func newMenuOption(code string, h RouteHandler, name string, sub ...*MenuOption) (*MenuOption, error) {
 	if IsEmptyText(name) {
-		panic(fmt.Errorf("option: name cannot be blank"))
+		return nil, fmt.Errorf("option: name cannot be blank")
 	}
-	return &MenuOption{code: code, handler: h, name: name, sub: sub}
+	matcher, err := parseCode(code)
+	if err != nil {
+		return nil, err
+	}
+	return &MenuOption{code: code, matcher: matcher, handler: h, handlerID: handlerPointer(h), name: name, sub: sub}, nil
 }
 
 var (
+	// WithStorage Sets the storage sessions are kept in, e.g. one shared by
+	// several replicas. By default sessions are kept in memory, see
+	// WithSessionTimes(). The storage is closed by Engine.Close().
+	WithStorage = func(s Storage) RouterOption {
+		return func(r *Engine) error {
+			r.Storage = s
+			return nil
+		}
+	}
 	WithSessionTimes = func(probeFrequency, timeToEviction time.Duration) RouterOption {
 		return func(r *Engine) error {
 			r.storageFrequency = probeFrequency
@@ -208,6 +663,16 @@ var (
 			return nil
 		}
 	}
+	// WithSessionLifetime Sets the age at which sessions are removed from the
+	// in-memory storage even if they are still in use. By default sessions are
+	// only removed once they are not accessed for the eviction time set
+	// through WithSessionTimes().
+	WithSessionLifetime = func(maxLifetime time.Duration) RouterOption {
+		return func(r *Engine) error {
+			r.storageLifetime = maxLifetime
+			return nil
+		}
+	}
 	DebugMode = func(r *Engine) error {
 		r.Debug = true
 		switch v := r.Log.(type) {
@@ -216,6 +681,109 @@ var (
 		}
 		return nil
 	}
+	// WithNavigationCodes Sets global codes for navigating back to the previous
+	// screen and home to the index screen. These codes are checked before the
+	// options of the current screen. Use an empty code to disable either.
+	WithNavigationCodes = func(back, home string) RouterOption {
+		return func(r *Engine) error {
+			r.backCode = back
+			r.homeCode = home
+			return nil
+		}
+	}
+	// WithInvalidOption Shows the current screen again, with the text as a
+	// banner, when the user enters an option that matches none of the options
+	// of the screen. Engine.NotFound is called on the given number of invalid
+	// attempts within a session. Screens can override these settings through
+	// MenuOption.InvalidOption().
+	WithInvalidOption = func(text string, maxAttempts int) RouterOption {
+		return func(r *Engine) error {
+			if maxAttempts <= 0 {
+				return fmt.Errorf("invalid option: maximum attempts must be positive")
+			}
+			r.invalidOption = &InvalidOption{Text: text, MaxAttempts: maxAttempts}
+			return nil
+		}
+	}
+	// WithResume Offers users who dial again after their session was dropped
+	// by the network to continue where they left off. The last session of each
+	// MSISDN is kept in the storage, and when the same MSISDN dials again
+	// within the window, its session data and screen are restored into the
+	// new session if the user chooses to continue. Use DefaultResume for
+	// sensible defaults.
+	WithResume = func(resume Resume) RouterOption {
+		return func(r *Engine) error {
+			if err := resume.validate(); err != nil {
+				return err
+			}
+			r.resume = &resume
+			return nil
+		}
+	}
+	// WithPagination Splits continue responses longer than the configured
+	// length into pages. Use DefaultPagination for sensible defaults.
+	//
+	// Paging controls are handled by the engine without calling the handler
+	// again. Any other value entered is routed as usual.
+	WithPagination = func(p Pagination) RouterOption {
+		return func(r *Engine) error {
+			if p.MoreCode == "" || p.BackCode == "" || p.capacity() <= 0 {
+				return fmt.Errorf("invalid pagination settings")
+			}
+			r.pagination = &p
+			return nil
+		}
+	}
+	// WithMenuSpec Loads the menu tree from a JSON or YAML spec file and
+	// registers it. Handlers referenced in the spec are looked up in the
+	// registry. See MenuSpec for details.
+	WithMenuSpec = func(fsys fs.FS, name string, handlers HandlerRegistry) RouterOption {
+		return func(r *Engine) error {
+			specs, err := LoadMenuSpec(fsys, name)
+			if err != nil {
+				return err
+			}
+			opts, err := BuildMenu(specs, handlers)
+			if err != nil {
+				return err
+			}
+			return r.RegisterMenuOptions(opts...)
+		}
+	}
+	// WithResponseDeadline Sets the time the engine has to respond to the
+	// gateway, and the text the session is ended with when the deadline (or
+	// that of a menu option, see MenuOption.Timeout()) passes.
+	//
+	// Handlers can observe the deadline through UssdRequest.Context().
+	WithResponseDeadline = func(budget time.Duration, fallback string) RouterOption {
+		return func(r *Engine) error {
+			r.responseBudget = budget
+			if !IsEmptyText(fallback) {
+				r.deadlineText = fallback
+			}
+			return nil
+		}
+	}
+	// WithEndGracePeriod Keeps a session and its routing state around for the
+	// given period after the END response is sent, for gateways that retry
+	// the final request. A retried request gets the same END response,
+	// without calling the handlers again. By default sessions are removed
+	// immediately. Sessions
+	// still within their grace period are removed by Engine.Close().
+	WithEndGracePeriod = func(d time.Duration) RouterOption {
+		return func(r *Engine) error {
+			r.endGracePeriod = d
+			return nil
+		}
+	}
+	// WithShutdownMessage Sets the text the session is ended with for requests
+	// received after Engine.Close() is called.
+	WithShutdownMessage = func(text string) RouterOption {
+		return func(r *Engine) error {
+			r.shutdownText = text
+			return nil
+		}
+	}
 	WithRouter = func(routerName string) RouterOption {
 		return func(r *Engine) error {
 			if instance, ok := registry.Load(routerName); ok {
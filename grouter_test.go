@@ -10,7 +10,12 @@ import (
 	"github.com/SharkFourSix/grouter/routers/at" // include africastalking implementation
 )
 
+// Serves the example menu on :1234 for use with a USSD simulator. Set
+// GROUTER_EXAMPLE_SERVER=1 to run it, as it never returns.
 func TestMain(t *testing.T) {
+	if os.Getenv("GROUTER_EXAMPLE_SERVER") == "" {
+		t.Skip("set GROUTER_EXAMPLE_SERVER=1 to serve the example menu")
+	}
 	e, err := grouter.NewRouterEngine(
 		grouter.DebugMode,
 		grouter.WithRouter(at.RouterName),
@@ -0,0 +1,183 @@
+package grouter
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// SessionEndReason Reason a session ended.
+type SessionEndReason int
+
+const (
+	// SessionEnded The session was ended by an END response
+	SessionEnded SessionEndReason = iota
+	// SessionEvicted The session expired and was evicted from storage
+	SessionEvicted
+	// SessionInterrupted The session was dropped by the network, and the user
+	// chose to resume it or to start over after dialling again. See
+	// WithResume().
+	SessionInterrupted
+)
+
+func (r SessionEndReason) String() string {
+	switch r {
+	case SessionEnded:
+		return "ended"
+	case SessionEvicted:
+		return "evicted"
+	case SessionInterrupted:
+		return "interrupted"
+	}
+	return "unknown"
+}
+
+// Hooks Callbacks for session lifecycle events. Any of them can be nil.
+//
+// Hooks are called synchronously, from the goroutine serving the request, or
+// from the storage sweeper in the case of evictions.
+type Hooks struct {
+	// SessionStart Called on the first request of a session, before routing
+	SessionStart func(request UssdRequest)
+	// SessionEnd Called once per session, when an END response is sent or
+	// when the session is evicted from storage without having ended
+	SessionEnd func(session UssdSession, reason SessionEndReason)
+	// ScreenEnter Called when a request moves the session to a new screen
+	ScreenEnter func(request UssdRequest, screen string)
+	// ScreenLeave Called when a request moves the session away from a screen
+	ScreenLeave func(request UssdRequest, screen string)
+}
+
+// EvictionNotifier Implemented by storages that can report the sessions they
+// evict. The engine uses this to fire the SessionEnd hook.
+type EvictionNotifier interface {
+	OnEvict(callback func(session UssdSession))
+}
+
+const (
+	sessionStartedKey = "grouter.started"
+	sessionEndedKey   = "grouter.ended"
+	// Final response of the session, sent again to gateways that retry the
+	// last request within the end grace period
+	endResponseKey = "grouter.endResponse"
+	// Response last sent in the session, sent again to gateways that retry
+	// a request
+	sentResponseKey = "grouter.sentResponse"
+)
+
+// Returns true on the first request of the session
+func (e *Engine) sessionStarted(request UssdRequest) bool {
+	session := request.Session()
+	if _, ok := session.Get(sessionStartedKey); ok {
+		return false
+	}
+	session.Set(sessionStartedKey, true)
+	if e.Hooks.SessionStart != nil {
+		e.Hooks.SessionStart(request)
+	}
+	return true
+}
+
+func (e *Engine) sessionEnded(session UssdSession, reason SessionEndReason) {
+	if _, ok := session.Get(sessionEndedKey); ok {
+		return
+	}
+	session.Set(sessionEndedKey, true)
+	e.Log.Printf("session=%s, %s", session.ID(), reason)
+	e.cancelDeferred(session)
+	if reason != SessionEvicted {
+		e.dropSession(session)
+	}
+	if e.Hooks.SessionEnd != nil {
+		e.Hooks.SessionEnd(session, reason)
+	}
+}
+
+// Removes the session and its routing state once the END response is sent,
+// after the grace period if one is configured. Pending removals are tracked
+// so that Close() can carry them out before closing the storage. The session
+// can no longer be resumed from then on.
+func (e *Engine) dropSession(session UssdSession) {
+	e.forgetSession(session)
+	drop := func() {
+		e.Storage.Del(session.ID())
+	}
+	e.lifecycle.Lock()
+	defer e.lifecycle.Unlock()
+	if e.endGracePeriod <= 0 || e.closed {
+		drop()
+		return
+	}
+	if e.pendingDrops == nil {
+		e.pendingDrops = map[*time.Timer]func(){}
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(e.endGracePeriod, func() {
+		e.lifecycle.Lock()
+		_, pending := e.pendingDrops[timer]
+		delete(e.pendingDrops, timer)
+		e.lifecycle.Unlock()
+		if pending {
+			drop()
+		}
+	})
+	e.pendingDrops[timer] = drop
+}
+
+// Keeps the final response of a session that is ended outside of the handlers,
+// e.g. on an error, so that it can be sent again on a retry
+func (e *Engine) keepEndResponse(session UssdSession, response string) {
+	if _, ended := session.Get(sessionEndedKey); ended {
+		return
+	}
+	session.Set(endResponseKey, response)
+	e.Storage.Set(session.ID(), session)
+}
+
+// Sends the previous response again without routing the request: the final
+// response to any request of an ended session, and the last response to a
+// request retried by the gateway. Returns false if the request is to be
+// routed.
+func (e *Engine) replay(w http.ResponseWriter, request UssdRequest) bool {
+	var (
+		session  = request.Session()
+		response string
+	)
+	if LoadSessionValue(session, endResponseKey, &response) {
+		e.Log.Printf("session=%s, repeating the final response", session.ID())
+	} else if retry, ok := request.(RetryAware); ok && retry.Retried() && LoadSessionValue(session, sentResponseKey, &response) {
+		e.Log.Printf("session=%s, repeating the last response to a retried request", session.ID())
+	} else {
+		return false
+	}
+	_, err := io.WriteString(w, response)
+	if err != nil {
+		e.Log.Printf(err.Error())
+	}
+	return true
+}
+
+// Carries out the removals still within their grace period
+func (e *Engine) flushDrops() {
+	e.lifecycle.Lock()
+	drops := e.pendingDrops
+	e.pendingDrops = nil
+	e.lifecycle.Unlock()
+	for timer, drop := range drops {
+		timer.Stop()
+		drop()
+	}
+}
+
+func (e *Engine) screenChanged(request UssdRequest, previous string) {
+	current := currentScreen(request.Session())
+	if current == previous {
+		return
+	}
+	if previous != "" && e.Hooks.ScreenLeave != nil {
+		e.Hooks.ScreenLeave(request, previous)
+	}
+	if current != "" && e.Hooks.ScreenEnter != nil {
+		e.Hooks.ScreenEnter(request, current)
+	}
+}
@@ -0,0 +1,75 @@
+package grouter
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// InvalidOption Settings for retrying input that matches none of the options
+// of the current screen. Instead of calling Engine.NotFound, the engine sends
+// the last response of the screen again with the text as a banner. The
+// handler of the screen is not called again.
+type InvalidOption struct {
+	// Banner shown above the screen, e.g. "Invalid option, try again"
+	Text string
+	// Number of invalid attempts within a session on which Engine.NotFound is
+	// called instead, which ends the session by default.
+	MaxAttempts int
+}
+
+const invalidAttemptsKey = "grouter.invalidAttempts"
+
+// InvalidOption Overrides the invalid option settings of the engine for the
+// screen of this option. See WithInvalidOption().
+func (o *MenuOption) InvalidOption(text string, maxAttempts int) *MenuOption {
+	o.invalid = &InvalidOption{Text: text, MaxAttempts: maxAttempts}
+	return o
+}
+
+// Returns the invalid option settings of the screen, or nil if invalid options
+// are not retried.
+func (e *Engine) invalidOptionFor(rc *routing, screen string) *InvalidOption {
+	for _, opt := range rc.menu.options {
+		if opt.name == screen && opt.invalid != nil {
+			return opt.invalid
+		}
+	}
+	return e.invalidOption
+}
+
+// Handles input that matches no option of the screen, either by repeating the
+// last response of the screen or by calling Engine.NotFound.
+func (e *Engine) notFound(rc *routing, screen string) {
+	var (
+		settings = e.invalidOptionFor(rc, screen)
+		session  = rc.request.Session()
+		last     string
+	)
+	if settings == nil || screen == "" || !LoadSessionValue(session, lastResponseKey, &last) || last == "" {
+		e.NotFound(rc.request)
+		return
+	}
+	attempts := 0
+	LoadSessionValue(session, invalidAttemptsKey, &attempts)
+	attempts++
+	session.Set(invalidAttemptsKey, attempts)
+	e.Log.Printf("invalid option, screen=%s, attempts=%d/%d", screen, attempts, settings.MaxAttempts)
+	if attempts >= settings.MaxAttempts {
+		e.NotFound(rc.request)
+		return
+	}
+	rc.writer.reset()
+	rc.writer.Printf("%s", last)
+	rc.writer.banner = settings.Text
+}
+
+// Inserts the banner as the first line of a continue response
+func (r *BufferedResponse) insertBanner() {
+	if r.banner == "" || !bytes.HasPrefix(r.buf.Bytes(), []byte(continuePrefix)) {
+		return
+	}
+	body := bytes.Clone(r.buf.Bytes()[len(continuePrefix):])
+	r.buf.Reset()
+	fmt.Fprintf(&r.buf, "%s%s\n", continuePrefix, r.banner)
+	r.buf.Write(body)
+}
@@ -12,6 +12,8 @@ type BufferedResponse struct {
 	templateName string
 	values       TemplateValues
 	end          bool
+	redirect     string
+	banner       string
 }
 
 func (r *BufferedResponse) RenderTemplate(name string, values TemplateValues, end bool) {
@@ -26,7 +28,7 @@ func (r *BufferedResponse) RenderContinueTemplate(name string, values TemplateVa
 }
 
 func (r *BufferedResponse) RenderEndTemplate(name string, values TemplateValues) {
-	r.RenderTemplate(name, values, false)
+	r.RenderTemplate(name, values, true)
 }
 
 func (r *BufferedResponse) Write(p []byte) (int, error) {
@@ -37,3 +39,20 @@ func (r *BufferedResponse) Write(p []byte) (int, error) {
 func (r *BufferedResponse) Printf(format string, args ...any) {
 	_, _ = fmt.Fprintf(&r.buf, format, args...)
 }
+
+// Redirect Discards the buffered response and asks the routing engine to run
+// the handler of the named screen within the same request.
+func (r *BufferedResponse) Redirect(screen string) {
+	r.reset()
+	r.redirect = screen
+}
+
+// Discards the buffered response
+func (r *BufferedResponse) reset() {
+	r.redirect = ""
+	r.banner = ""
+	r.templateName = ""
+	r.values = nil
+	r.end = false
+	r.buf.Reset()
+}
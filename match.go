@@ -0,0 +1,114 @@
+package grouter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Kind of menu option code. The order of the constants defines the matching
+// precedence used by the routing engine: exact codes are tried first, then
+// ranges, then patterns and finally wildcards.
+type codeKind int
+
+const (
+	exactCode codeKind = iota
+	rangeCode
+	patternCode
+	wildcardCode
+)
+
+var rangeCodeExpr = regexp.MustCompile(`^(\d+)-(\d+)$`)
+
+// Parsed menu option code
+type codeMatcher struct {
+	kind    codeKind
+	raw     string
+	low     int
+	high    int
+	pattern *regexp.Regexp
+}
+
+// Parses a menu option code.
+//
+//	"*"        matches any input
+//	"1-9"      matches any integer in the (inclusive) range
+//	"/^\d+$/"  matches input against the regular expression
+//
+// Anything else is matched literally.
+func parseCode(code string) (codeMatcher, error) {
+	m := codeMatcher{kind: exactCode, raw: code}
+	switch {
+	case code == "*":
+		m.kind = wildcardCode
+	case len(code) > 2 && strings.HasPrefix(code, "/") && strings.HasSuffix(code, "/"):
+		re, err := regexp.Compile(code[1 : len(code)-1])
+		if err != nil {
+			return m, fmt.Errorf("option: invalid pattern `%s`: %w", code, err)
+		}
+		m.kind = patternCode
+		m.pattern = re
+	default:
+		if groups := rangeCodeExpr.FindStringSubmatch(code); groups != nil {
+			low, _ := strconv.Atoi(groups[1])
+			high, _ := strconv.Atoi(groups[2])
+			if low > high {
+				return m, fmt.Errorf("option: invalid range `%s`", code)
+			}
+			m.kind = rangeCode
+			m.low = low
+			m.high = high
+		}
+	}
+	return m, nil
+}
+
+func (m codeMatcher) match(value string) bool {
+	switch m.kind {
+	case wildcardCode:
+		return true
+	case rangeCode:
+		n, err := strconv.Atoi(value)
+		return err == nil && n >= m.low && n <= m.high
+	case patternCode:
+		return m.pattern.MatchString(value)
+	default:
+		return m.raw == value
+	}
+}
+
+// Finds the option within the given screen that best matches the value,
+// honouring the code precedence. Returns -1 if there is no match.
+func matchOption(options []*MenuOption, screen, value string) int {
+	return bestMatch(options, value, func(mo *MenuOption) bool {
+		return !mo.global && mo.parentScreen == screen
+	})
+}
+
+// Finds the global option that best matches the value. Global options are
+// overridden by options of the screen that have the same code.
+func matchGlobal(options []*MenuOption, screen, value string) int {
+	return bestMatch(options, value, func(mo *MenuOption) bool {
+		return mo.global && !slices.ContainsFunc(options, func(other *MenuOption) bool {
+			return !other.global && other.parentScreen == screen && other.code == mo.code
+		})
+	})
+}
+
+func bestMatch(options []*MenuOption, value string, candidate func(mo *MenuOption) bool) int {
+	index := -1
+	for i, mo := range options {
+		if !mo.matcher.match(value) || !candidate(mo) {
+			continue
+		}
+		if index == -1 || mo.matcher.kind < options[index].matcher.kind {
+			index = i
+			if mo.matcher.kind == exactCode {
+				break
+			}
+		}
+	}
+	return index
+}
@@ -0,0 +1,53 @@
+package grouter
+
+import "testing"
+
+func TestMatchOptionPrecedence(t *testing.T) {
+	h := func(request UssdRequest) bool { return false }
+	options := []*MenuOption{
+		NewMenuOption("*", h, "wildcard"),
+		NewMenuOption(`/^\d{6,}$/`, h, "pattern"),
+		NewMenuOption("1-5", h, "range"),
+		NewMenuOption("3", h, "exact"),
+		NewMenuOption("1", h, "otherScreen"),
+	}
+	for _, opt := range options[:4] {
+		opt.parentScreen = "main"
+	}
+	tests := []struct {
+		value string
+		name  string
+	}{
+		{"3", "exact"},
+		{"4", "range"},
+		{"123456", "pattern"},
+		{"#", "wildcard"},
+	}
+	for _, test := range tests {
+		index := matchOption(options, "main", test.value)
+		if index == -1 {
+			t.Fatalf("%s: no match", test.value)
+		}
+		if options[index].name != test.name {
+			t.Errorf("%s: expected %s, got %s", test.value, test.name, options[index].name)
+		}
+	}
+	if index := matchOption(options, "other", "1"); index != -1 {
+		t.Errorf("expected no match, got %s", options[index].name)
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	for _, code := range []string{"9-1", "/(/"} {
+		if _, err := parseCode(code); err == nil {
+			t.Errorf("%s: expected error", code)
+		}
+	}
+	m, err := parseCode("10-20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.match("15") || m.match("21") || m.match("abc") {
+		t.Errorf("unexpected range matching")
+	}
+}
@@ -0,0 +1,45 @@
+package grouter
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A menu tree with its own index screen. The engine serves a tree for each
+// registered service code, and a fallback tree for any other service code.
+type menuTree struct {
+	options     []*MenuOption
+	indexScreen string
+}
+
+func (m *menuTree) mapOption(opt *MenuOption, parent *MenuOption) {
+	if opt != nil {
+		if parent != nil {
+			opt.parentScreen = parent.name
+		}
+		m.options = append(m.options, opt)
+		for _, subOpt := range opt.sub {
+			m.mapOption(subOpt, opt)
+		}
+	}
+}
+
+func (m *menuTree) add(opts ...*MenuOption) {
+	// create map
+	for _, opt := range opts {
+		if opt.code == "" {
+			if m.indexScreen != "" {
+				panic(fmt.Errorf("index screen is already set to `%s`", m.indexScreen))
+			} else {
+				m.indexScreen = opt.name
+			}
+		}
+		m.mapOption(opt, nil)
+	}
+}
+
+func (m *menuTree) optionByName(name string) int {
+	return slices.IndexFunc(m.options, func(mo *MenuOption) bool {
+		return mo.name == name
+	})
+}
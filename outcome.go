@@ -0,0 +1,114 @@
+package grouter
+
+import "errors"
+
+type outcomeKind int
+
+const (
+	stayOutcome outcomeKind = iota
+	advanceOutcome
+	backOutcome
+	homeOutcome
+	redirectOutcome
+	endOutcome
+)
+
+// Outcome Result of an OutcomeHandler telling the routing engine how to
+// update the routing state.
+type Outcome struct {
+	kind   outcomeKind
+	screen string
+}
+
+var (
+	// Stay Remain in the current screen context. Same as returning true from
+	// a RouteHandler.
+	Stay = Outcome{kind: stayOutcome}
+	// Advance Make the screen of the handler's option the current screen.
+	// Same as returning false from a RouteHandler.
+	Advance = Outcome{kind: advanceOutcome}
+	// Back Discard the handler's response and show the previous screen in the
+	// navigation history.
+	Back = Outcome{kind: backOutcome}
+	// Home Discard the handler's response, clear the navigation history and
+	// show the index screen.
+	Home = Outcome{kind: homeOutcome}
+	// EndSession The handler has ended the session. The routing state of the
+	// session is dropped.
+	EndSession = Outcome{kind: endOutcome}
+)
+
+// Redirect Discard the handler's response and run the handler of the named
+// screen. See UssdRequest.Redirect().
+func Redirect(screenName string) Outcome {
+	return Outcome{kind: redirectOutcome, screen: screenName}
+}
+
+// OutcomeHandler USSD request handler returning a rich outcome instead of a
+// bool. RouteHandler remains supported as is: returning true is Stay, and
+// false is Advance. Middleware wrapping an OutcomeHandler sees whether it
+// stays but cannot change its outcome.
+type OutcomeHandler func(request UssdRequest) Outcome
+
+// The outcome is recorded in the route of the request so that handlers can
+// still be wrapped by middleware, which only sees whether the handler stays.
+func (h OutcomeHandler) routeHandler() RouteHandler {
+	if h == nil {
+		return nil
+	}
+	return func(request UssdRequest) bool {
+		outcome := h(request)
+		if route := CurrentRoute(request); route != nil {
+			route.outcome = &outcome
+		}
+		return outcome.kind == stayOutcome
+	}
+}
+
+// NewOutcomeMenuOption Creates a menu option whose handler returns an
+// Outcome. See NewMenuOption().
+func NewOutcomeMenuOption(code string, h OutcomeHandler, name string, sub ...*MenuOption) *MenuOption {
+	opt := NewMenuOption(code, h.routeHandler(), name, sub...)
+	opt.handlerID = handlerPointer(h)
+	return opt
+}
+
+// RouteHandlerE USSD request handler that can fail. Returning nil advances
+// the screen context, like returning false from a RouteHandler. Return
+// ErrStayOnScreen to remain in the same screen context. Any other error
+// discards the handler's response and is passed on to Engine.ErrorHandler.
+type RouteHandlerE func(request UssdRequest) error
+
+// ErrorHandler Handles errors returned by handlers, as well as panics, which
+// are passed on as *PanicError. The handler should write a response for the
+// user, such as a retry prompt or a friendly END, and return the outcome.
+//
+// The default error handler of the engine ends the session.
+type ErrorHandler func(request UssdRequest, err error) Outcome
+
+func (h RouteHandlerE) routeHandler() RouteHandler {
+	if h == nil {
+		return nil
+	}
+	return func(request UssdRequest) bool {
+		err := h(request)
+		switch {
+		case err == nil:
+			return false
+		case errors.Is(err, ErrStayOnScreen):
+			return true
+		}
+		if route := CurrentRoute(request); route != nil {
+			route.err = err
+		}
+		return true
+	}
+}
+
+// NewMenuOptionE Creates a menu option whose handler can fail. See
+// NewMenuOption() and RouteHandlerE.
+func NewMenuOptionE(code string, h RouteHandlerE, name string, sub ...*MenuOption) *MenuOption {
+	opt := NewMenuOption(code, h.routeHandler(), name, sub...)
+	opt.handlerID = handlerPointer(h)
+	return opt
+}
@@ -0,0 +1,147 @@
+package grouter
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pagination Settings for splitting long responses into pages.
+type Pagination struct {
+	// Maximum number of characters sent to the user per page, including the
+	// response type prefix and the paging controls.
+	MaxLength int
+	// Code and label of the control that shows the next page
+	MoreCode  string
+	MoreLabel string
+	// Code and label of the control that shows the previous page
+	BackCode  string
+	BackLabel string
+}
+
+// DefaultPagination Pagination settings that fit most USSD gateways.
+var DefaultPagination = Pagination{
+	MaxLength: 160,
+	MoreCode:  "98",
+	MoreLabel: "More",
+	BackCode:  "0",
+	BackLabel: "Back",
+}
+
+const (
+	pagesKey       = "grouter.pages"
+	pageCursorKey  = "grouter.pageCursor"
+	continuePrefix = "CON "
+)
+
+func (p Pagination) moreControl() string {
+	return fmt.Sprintf("%s. %s", p.MoreCode, p.MoreLabel)
+}
+
+func (p Pagination) backControl() string {
+	return fmt.Sprintf("%s. %s", p.BackCode, p.BackLabel)
+}
+
+// Number of characters available for content on each page. Room is always
+// reserved for both controls, the line breaks before them and the trailing
+// line break, to keep splitting simple.
+func (p Pagination) capacity() int {
+	return p.MaxLength - len(continuePrefix) -
+		utf8.RuneCountInString(p.moreControl()) - utf8.RuneCountInString(p.backControl()) - 3
+}
+
+// Splits the body into pages and appends the paging controls to each page
+func (p Pagination) split(body string) []string {
+	var (
+		capacity = p.capacity()
+		pages    []string
+		current  []string
+		length   int
+	)
+	flush := func() {
+		if len(current) > 0 {
+			pages = append(pages, strings.Join(current, "\n"))
+			current = nil
+			length = 0
+		}
+	}
+	for _, line := range strings.Split(body, "\n") {
+		for utf8.RuneCountInString(line) > capacity {
+			flush()
+			runes := []rune(line)
+			pages = append(pages, string(runes[:capacity]))
+			line = string(runes[capacity:])
+		}
+		n := utf8.RuneCountInString(line)
+		if len(current) > 0 && length+1+n > capacity {
+			flush()
+		}
+		if len(current) > 0 {
+			length++
+		}
+		current = append(current, line)
+		length += n
+	}
+	flush()
+	for i := range pages {
+		if i < len(pages)-1 {
+			pages[i] += "\n" + p.moreControl()
+		}
+		if i > 0 {
+			pages[i] += "\n" + p.backControl()
+		}
+	}
+	return pages
+}
+
+// Splits an oversized continue response into pages, keeping the pages in the
+// session and replacing the response with the first page.
+func (e *Engine) paginate(request UssdRequest, writer *BufferedResponse) {
+	response := writer.buf.String()
+	if e.pagination == nil || !strings.HasPrefix(response, continuePrefix) ||
+		utf8.RuneCountInString(response) <= e.pagination.MaxLength {
+		return
+	}
+	pages := e.pagination.split(strings.TrimRight(strings.TrimPrefix(response, continuePrefix), "\n"))
+	request.Session().Set(pagesKey, pages)
+	request.Session().Set(pageCursorKey, 0)
+	writer.buf.Reset()
+	writer.Printf("%s%s\n", continuePrefix, pages[0])
+}
+
+// Shows the next or previous page if the user selected a paging control.
+// Any other value discards the pages so that it can be routed as usual.
+// The page is already split, so it must not be paginated again.
+func (e *Engine) turnPage(request UssdRequest, writer *BufferedResponse) bool {
+	if e.pagination == nil {
+		return false
+	}
+	var (
+		session = request.Session()
+		pages   []string
+		cursor  int
+	)
+	if !LoadSessionValue(session, pagesKey, &pages) {
+		return false
+	}
+	LoadSessionValue(session, pageCursorKey, &cursor)
+	// Paging works the same whether the screen reads options or input
+	code := request.Input()
+	if code == "" {
+		code = request.Option()
+	}
+	switch {
+	case code == e.pagination.MoreCode && cursor < len(pages)-1:
+		cursor++
+	case code == e.pagination.BackCode && cursor > 0:
+		cursor--
+	default:
+		session.Del(pagesKey)
+		session.Del(pageCursorKey)
+		return false
+	}
+	e.Log.Printf("page=%d/%d", cursor+1, len(pages))
+	session.Set(pageCursorKey, cursor)
+	writer.Printf("%s%s\n", continuePrefix, pages[cursor])
+	return true
+}
@@ -0,0 +1,189 @@
+package grouter
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Resume Settings for offering users whose session was dropped by the network
+// to continue where they left off when they dial the same service code again.
+// Continuing restores the session values of the interrupted session and sends
+// its last response again.
+type Resume struct {
+	// Time since the last request of the interrupted session within which it
+	// can be resumed. It should not exceed the session eviction time, see
+	// WithSessionTimes().
+	Window time.Duration
+	// Text of the choice offered to the user
+	Prompt string
+	// Codes of the choices to continue the interrupted session and to start
+	// over
+	ContinueCode  string
+	StartOverCode string
+}
+
+// DefaultResume Resume settings with a window of one minute.
+var DefaultResume = Resume{
+	Window:        time.Minute,
+	Prompt:        "1. Continue where you left off\n2. Start over",
+	ContinueCode:  "1",
+	StartOverCode: "2",
+}
+
+const (
+	// Storage key prefix of the last session of an MSISDN on a service code
+	resumeKeyPrefix = "grouter.resume:"
+	// Storage key the session is remembered under
+	resumeKeyKey = "grouter.resumeKey"
+	// ID of the interrupted session offered to the user
+	resumePendingKey = "grouter.resumePending"
+)
+
+// Session keys that belong to a single session and are not restored
+var sessionOnlyKeys = map[string]bool{
+	sessionStartedKey: true,
+	sessionEndedKey:   true,
+	resumePendingKey:  true,
+	resumeKeyKey:      true,
+	deferredKey:       true,
+	endResponseKey:    true,
+	sentResponseKey:   true,
+	pagesKey:          true,
+	pageCursorKey:     true,
+}
+
+// Returns the storage key of the last session of the MSISDN of the request on
+// its service code
+func resumeKey(request UssdRequest) string {
+	return resumeKeyPrefix + request.MSISDN() + ":" + request.ServiceCode()
+}
+
+// Remembers the session as the last one of its MSISDN on its service code
+// once it has moved past the index screen. A session showing the index screen
+// is forgotten, there being nothing to resume. Responses that are not produced
+// by a handler, e.g. repeated ones, only update a session already remembered.
+func (e *Engine) rememberSession(rc *routing) {
+	var (
+		request = rc.request
+		session = request.Session()
+		current = rc.current.Load()
+	)
+	if e.resume == nil {
+		return
+	}
+	if current == nil {
+		if _, remembered := session.Get(resumeKeyKey); !remembered {
+			return
+		}
+	} else if currentScreen(session) == "" || current.name == rc.menu.indexScreen {
+		e.forgetSession(session)
+		return
+	}
+	key := resumeKey(request)
+	session.Set(resumeKeyKey, key)
+	e.Storage.Set(key, session)
+}
+
+// Forgets the session as the last one of its MSISDN on its service code
+func (e *Engine) forgetSession(session UssdSession) {
+	var key string
+	if !LoadSessionValue(session, resumeKeyKey, &key) {
+		return
+	}
+	if last := e.Storage.Get(key); last != nil && last.ID() == session.ID() {
+		e.Storage.Del(key)
+	}
+}
+
+// Returns the session if it was interrupted recently enough to be resumed
+func (e *Engine) resumable(session UssdSession, current UssdSession) UssdSession {
+	if session == nil || session.ID() == current.ID() || currentScreen(session) == "" {
+		return nil
+	}
+	// Sessions saved along with their final response have ended, even though
+	// they are kept during the end grace period
+	if _, ended := session.Get(sessionEndedKey); ended {
+		return nil
+	}
+	if _, ended := session.Get(endResponseKey); ended {
+		return nil
+	}
+	if time.Since(session.LastAccessed()) > e.resume.Window {
+		return nil
+	}
+	return session
+}
+
+// Offers to resume the interrupted session of the MSISDN on the first request
+// of a session, and handles the choice of the user on the next request.
+func (e *Engine) resumeJourney(rc *routing, first bool) bool {
+	if e.resume == nil {
+		return false
+	}
+	var (
+		request = rc.request
+		session = request.Session()
+	)
+	if first {
+		previous := e.resumable(e.Storage.Get(resumeKey(request)), session)
+		if previous == nil || request.Option() != "" {
+			return false
+		}
+		e.Log.Printf("session=%s, offering to resume session %s", session.ID(), previous.ID())
+		session.Set(resumePendingKey, previous.ID())
+		request.Continue("%s", e.resume.Prompt)
+		return true
+	}
+	value, ok := session.Get(resumePendingKey)
+	if !ok {
+		return false
+	}
+	id, _ := value.(string)
+	previous := e.resumable(e.Storage.Get(id), session)
+	switch code := request.Option(); {
+	case previous != nil && code == e.resume.ContinueCode:
+		session.Del(resumePendingKey)
+		for _, key := range previous.Keys() {
+			if value, ok := previous.Get(key); ok && !sessionOnlyKeys[key] && !e.transientKey(key) {
+				session.Set(key, value)
+			}
+		}
+		e.sessionEnded(previous, SessionInterrupted)
+		e.Log.Printf("session=%s, resumed session %s at screen `%s`", session.ID(), previous.ID(), currentScreen(session))
+		// The last response is sent again rather than calling the handler of
+		// the screen, whose input was read by the interrupted session
+		var last string
+		if LoadSessionValue(session, lastResponseKey, &last) && last != "" {
+			rc.writer.Printf("%s", last)
+			session.Set(resumeKeyKey, resumeKey(request))
+		} else {
+			resetScreens(session)
+			e.home(rc)
+		}
+	case previous == nil || code == e.resume.StartOverCode:
+		session.Del(resumePendingKey)
+		if previous != nil {
+			e.sessionEnded(previous, SessionInterrupted)
+		}
+		e.home(rc)
+	default:
+		request.Continue("%s", e.resume.Prompt)
+	}
+	return true
+}
+
+// Returns true if the router keeps the session value for a single session
+func (e *Engine) transientKey(key string) bool {
+	if router, ok := e.router.(TransientKeyRouter); ok {
+		return slices.Contains(router.TransientSessionKeys(), key)
+	}
+	return false
+}
+
+func (r Resume) validate() error {
+	if r.Window <= 0 || r.ContinueCode == "" || r.StartOverCode == "" || r.ContinueCode == r.StartOverCode {
+		return fmt.Errorf("invalid resume settings")
+	}
+	return nil
+}
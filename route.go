@@ -0,0 +1,41 @@
+package grouter
+
+import "context"
+
+// Route Information about the menu option matched by the routing engine for
+// the current request.
+type Route struct {
+	// Screen the request was received in
+	Screen string
+	// Name of the matched menu option
+	Name string
+	// Code of the matched menu option
+	Code string
+
+	outcome *Outcome
+	err     error
+}
+
+const routeAttribute = "grouter.route"
+
+type routeContextKey struct{}
+
+// CurrentRoute Returns the route matched for the request, or nil if the
+// request was not routed through the engine.
+func CurrentRoute(request UssdRequest) *Route {
+	if route, ok := request.GetAttribute(routeAttribute).(*Route); ok {
+		return route
+	}
+	return nil
+}
+
+// RouteFromContext Returns the route carried by the context of a request
+// passed on to a handler, or nil if there is none. This makes the matched
+// route available to code that only receives the context, such as service
+// calls made by the handler.
+func RouteFromContext(ctx context.Context) *Route {
+	if route, ok := ctx.Value(routeContextKey{}).(*Route); ok {
+		return route
+	}
+	return nil
+}
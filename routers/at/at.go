@@ -1,13 +1,12 @@
 package at
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
-	"time"
 
 	"github.com/SharkFourSix/grouter"
-	cmap "github.com/orcaman/concurrent-map"
 )
 
 const RouterName = "AfricasTalkingUSSDRouter"
@@ -66,76 +65,106 @@ func (r *router) CreateRequest(resp *grouter.BufferedResponse, req *http.Request
 		}
 	}
 
-	sess := store.Get(request.SessionId)
+	// Sessions loaded from the storage may be of any type, e.g. decoded by
+	// grouter.DecodeSession(), so they are only used through the interface.
+	var (
+		sess    = store.Get(request.SessionId)
+		retried bool
+		err     error
+	)
 	if grouter.IsEmptyText(request.Text) {
 		// New session
-		sess = &africasTalkingUssdSession{
-			startTime:             time.Now(),
-			readPointer:           0,
-			store:                 cmap.New(),
-			id:                    request.SessionId,
-			state:                 grouter.READ_OPTION,
-			autoAdjustReadPointer: false,
-		}
+		sess = newSession(request.SessionId)
 		store.Set(request.SessionId, sess)
 	} else {
 		if sess == nil {
 			return nil, fmt.Errorf("session %s not found", request.SessionId)
 		} else {
-			sess.(*africasTalkingUssdSession).Read(request)
+			if retried, err = read(sess, request); err != nil {
+				return nil, err
+			}
 		}
 	}
 	ussdRequest := ussd_request{
-		resp: resp,
-		data: request,
-		req:  req,
-		attr: map[string]any{},
-		sess: sess.(*africasTalkingUssdSession),
+		resp:    resp,
+		data:    request,
+		req:     req,
+		attr:    map[string]any{},
+		sess:    sess,
+		retried: retried,
 	}
 	return &ussdRequest, nil
 }
 
+// TransientSessionKeys Session values that only apply to the text of the
+// requests of a single session
+func (r *router) TransientSessionKeys() []string {
+	return []string{readPointerKey, autoAdjustKey, textKey}
+}
+
 type ussd_request struct {
 	resp *grouter.BufferedResponse
 	req  *http.Request
 	data *requestData
-	sess *africasTalkingUssdSession
+	sess grouter.UssdSession
 	attr map[string]any
+	// Set when the gateway sent the same text as the previous request
+	retried bool
+}
+
+// Retried Reports whether the request repeats the previous request of the
+// session
+func (r *ussd_request) Retried() bool {
+	return r.retried
+}
+
+func (r *ussd_request) value(key string) string {
+	var value string
+	grouter.LoadSessionValue(r.sess, key, &value)
+	return value
 }
 
 func (r *ussd_request) Session() grouter.UssdSession {
 	return r.sess
 }
 
+func (r *ussd_request) Context() context.Context {
+	return r.req.Context()
+}
+
 func (r *ussd_request) MSISDN() string {
 	return r.data.PhoneNumber
 }
 
+func (r *ussd_request) ServiceCode() string {
+	return r.data.ServiceCode
+}
+
 func (r *ussd_request) Option() string {
-	return r.sess.option
+	return r.value(optionKey)
 }
 
 func (r *ussd_request) Input() string {
-	return r.sess.input
+	return r.value(inputKey)
 }
 
 func (r *ussd_request) Continue(text string, args ...any) {
-	r.sess.state = grouter.READ_OPTION
+	r.sess.Set(stateKey, grouter.READ_OPTION)
 	_, _ = fmt.Fprintf(r.resp, "CON %s\n", fmt.Sprintf(text, args...))
 }
 
 func (r *ussd_request) ContinueWithTemplate(tmplName string, values grouter.TemplateValues) {
-	r.sess.state = grouter.READ_OPTION
+	r.sess.Set(stateKey, grouter.READ_OPTION)
 	r.resp.RenderContinueTemplate(tmplName, values)
 }
 
 func (r *ussd_request) Prompt(text string, args ...any) {
-	r.sess.state = grouter.READ_INPUT
+	r.sess.Set(stateKey, grouter.READ_INPUT)
 	_, _ = fmt.Fprintf(r.resp, "CON %s\n", fmt.Sprintf(text, args...))
 }
 
 func (r *ussd_request) PromptWithTemplate(tmplName string, values grouter.TemplateValues) {
-	r.sess.state = grouter.READ_INPUT
+	r.sess.Set(stateKey, grouter.READ_INPUT)
 	r.resp.RenderContinueTemplate(tmplName, values)
 }
 
@@ -147,6 +176,10 @@ func (r *ussd_request) EndWithTemplate(tmplName string, values grouter.TemplateV
 	r.resp.RenderEndTemplate(tmplName, values)
 }
 
+func (r *ussd_request) Redirect(screenName string) {
+	r.resp.Redirect(screenName)
+}
+
 func (r *ussd_request) SetAttribute(key string, value any) {
 	r.attr[key] = value
 }
@@ -4,41 +4,79 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/SharkFourSix/grouter"
 	cmap "github.com/orcaman/concurrent-map"
 )
 
+// The read state is kept with the session values rather than in fields, so
+// that sessions can be saved by storages that encode them.
+const (
+	readPointerKey = "at.readPointer"
+	stateKey       = "at.state"
+	optionKey      = "at.option"
+	inputKey       = "at.input"
+	autoAdjustKey  = "at.autoAdjustReadPointer"
+	// Text of the last request, to recognize requests retried by the gateway
+	textKey = "at.text"
+)
+
 type africasTalkingUssdSession struct {
-	startTime             time.Time
-	readPointer           int
-	store                 cmap.ConcurrentMap
-	state                 grouter.RouterState
-	option                string
-	input                 string
-	id                    string
-	autoAdjustReadPointer bool
+	startTime  time.Time
+	lastAccess atomic.Int64 // unix nanoseconds
+	store      cmap.ConcurrentMap
+	id         string
 }
 
-func (s *africasTalkingUssdSession) Read(request *requestData) {
+func newSession(id string) *africasTalkingUssdSession {
+	s := &africasTalkingUssdSession{
+		startTime: time.Now(),
+		store:     cmap.New(),
+		id:        id,
+	}
+	s.Set(readPointerKey, 0)
+	s.Set(stateKey, grouter.READ_OPTION)
+	s.Touch()
+	return s
+}
+
+// Reads the value entered since the previous request of the session. A
+// retried request, having the same text as the previous one, is not read
+// again and true is returned.
+func read(sess grouter.UssdSession, request *requestData) (bool, error) {
 	var (
 		value       string
 		inputLength = len(request.Text)
-		pointer     = s.readPointer
+		pointer     int
+		autoAdjust  bool
+		state       = grouter.READ_OPTION
+		text        string
 	)
-	if s.autoAdjustReadPointer {
+	if grouter.LoadSessionValue(sess, textKey, &text) && text == request.Text {
+		return true, nil
+	}
+	grouter.LoadSessionValue(sess, readPointerKey, &pointer)
+	grouter.LoadSessionValue(sess, autoAdjustKey, &autoAdjust)
+	grouter.LoadSessionValue(sess, stateKey, &state)
+	if autoAdjust {
 		pointer = min(pointer, inputLength)
 	}
+	if pointer > inputLength {
+		return false, fmt.Errorf("session %s: text is shorter than the text already read", sess.ID())
+	}
 	value = strings.Clone(request.Text[pointer:])
-	s.readPointer = inputLength + 1 // adjust pointer (account for asteriks)
-	switch s.state {
+	sess.Set(textKey, request.Text)
+	sess.Set(readPointerKey, inputLength+1) // adjust pointer (account for asteriks)
+	switch state {
 	case grouter.READ_INPUT: // previous option is kept
-		s.input = value
+		sess.Set(inputKey, value)
 	case grouter.READ_OPTION:
-		s.input = ""
-		s.option = value
+		sess.Set(inputKey, "")
+		sess.Set(optionKey, value)
 	}
+	return false, nil
 }
 
 func (s *africasTalkingUssdSession) ID() string {
@@ -49,6 +87,14 @@ func (s *africasTalkingUssdSession) CreatedAt() time.Time {
 	return s.startTime
 }
 
+func (s *africasTalkingUssdSession) LastAccessed() time.Time {
+	return time.Unix(0, s.lastAccess.Load())
+}
+
+func (s *africasTalkingUssdSession) Touch() {
+	s.lastAccess.Store(time.Now().UnixNano())
+}
+
 func (s *africasTalkingUssdSession) Set(key string, value any) {
 	s.store.Set(key, value)
 }
@@ -57,6 +103,10 @@ func (s *africasTalkingUssdSession) Del(key string) {
 	s.store.Remove(key)
 }
 
+func (s *africasTalkingUssdSession) Keys() []string {
+	return s.store.Keys()
+}
+
 func (s *africasTalkingUssdSession) Get(key string) (any, bool) {
 	return s.store.Get(key)
 }
@@ -74,11 +124,11 @@ func (s *africasTalkingUssdSession) MustGet(key string) any {
 //
 // The function will panic if the request is not provided by this module.
 func SetAutoAdjustReadPointer(request grouter.UssdRequest, autoAdjust bool) {
-	atRequest, ok := request.(*ussd_request)
+	atRequest, ok := grouter.UnwrapRequest(request).(*ussd_request)
 	if !ok {
 		panic(errors.New("expected AT ussd request instance"))
 	}
-	atRequest.sess.autoAdjustReadPointer = autoAdjust
+	atRequest.sess.Set(autoAdjustKey, autoAdjust)
 }
 
 // IsReadPointerAutoAdjusted Gets whether the read pointer is set to auto 
@@ -87,9 +137,11 @@ func SetAutoAdjustReadPointer(request grouter.UssdRequest, autoAdjust bool) {
 //
 // The function will panic if the request is not provided by this module.
 func IsReadPointerAutoAdjusted(request grouter.UssdRequest) bool {
-	atRequest, ok := request.(*ussd_request)
+	atRequest, ok := grouter.UnwrapRequest(request).(*ussd_request)
 	if !ok {
 		panic(errors.New("expected AT ussd request instance"))
 	}
-	return atRequest.sess.autoAdjustReadPointer
+	var autoAdjust bool
+	grouter.LoadSessionValue(atRequest.sess, autoAdjustKey, &autoAdjust)
+	return autoAdjust
 }
@@ -3,6 +3,7 @@ package grouter
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,14 +14,43 @@ type UssdSession interface {
 	Get(key string) (any, bool)
 	MustGet(key string) any
 	Del(key string)
+	// Keys Returns the keys of the values stored in the session
+	Keys() []string
 	CreatedAt() time.Time
+	// LastAccessed Time the session was last touched by a request
+	LastAccessed() time.Time
+	// Touch Marks the session as accessed. The engine touches the session on
+	// every request.
+	Touch()
 }
 
+// SessionExpiry Rules for removing sessions from a storage.
+type SessionExpiry struct {
+	// Sessions that are not accessed for this long are removed
+	Idle time.Duration
+	// Sessions that are this old are removed even if they are still in use.
+	// Zero means sessions are only removed when idle.
+	MaxLifetime time.Duration
+}
+
+// Expired Reports whether the session should be removed.
+func (x SessionExpiry) Expired(session UssdSession, now time.Time) bool {
+	if x.Idle > 0 && now.Sub(session.LastAccessed()) >= x.Idle {
+		return true
+	}
+	return x.MaxLifetime > 0 && now.Sub(session.CreatedAt()) >= x.MaxLifetime
+}
+
+// Storage Keeps sessions between requests. The routing state of a session,
+// i.e. its current screen and navigation history, is kept in the session, and
+// the engine saves the session through Set() after every response. Replicas
+// sharing a storage can therefore handle any request of a session.
 type Storage interface {
 	Set(key string, session UssdSession)
 	Get(key string) UssdSession
 	Del(key string)
-	// Vacuum removes sessions that are as old as the given duration
+	// Vacuum removes sessions that have not been accessed for the given
+	// duration, see SessionExpiry.
 	Vacuum(duration time.Duration)
 }
 
@@ -28,7 +58,23 @@ type inMemoryStore struct {
 	vacuumch chan bool
 	ticker   *time.Ticker
 	//store    cmap.ConcurrentMap
-	store sync.Map
+	store       sync.Map
+	onEvict     atomic.Value // func(UssdSession)
+	maxLifetime time.Duration
+	closeOnce   sync.Once
+}
+
+// Close Stops the vacuum goroutine. The sessions remain accessible.
+func (mss *inMemoryStore) Close() error {
+	mss.closeOnce.Do(func() {
+		close(mss.vacuumch)
+	})
+	return nil
+}
+
+// OnEvict Sets the callback invoked for each session removed by Vacuum().
+func (mss *inMemoryStore) OnEvict(callback func(session UssdSession)) {
+	mss.onEvict.Store(callback)
 }
 
 func (mss *inMemoryStore) Set(key string, sess UssdSession) {
@@ -47,22 +93,38 @@ func (mss *inMemoryStore) Del(key string) {
 }
 
 func (mss *inMemoryStore) Vacuum(duration time.Duration) {
-	var now = time.Now()
+	var (
+		now    = time.Now()
+		expiry = SessionExpiry{Idle: duration, MaxLifetime: mss.maxLifetime}
+	)
 	mss.store.Range(func(key, value any) bool {
 		session := value.(UssdSession)
-		lifespan := now.Sub(session.CreatedAt())
-		if lifespan >= duration {
+		if expiry.Expired(session, now) {
 			mss.store.Delete(key)
+			if callback, ok := mss.onEvict.Load().(func(UssdSession)); ok && callback != nil {
+				callback(session)
+			}
 		}
 		return true
 	})
 }
 
+// NewInMemorySessionStorage Creates a storage that removes sessions that are
+// not accessed for the given time to live. The storage is checked at the
+// given frequency.
 func NewInMemorySessionStorage(frequency, sessionTTL time.Duration) Storage {
+	return NewInMemorySessionStorageWithExpiry(frequency, SessionExpiry{Idle: sessionTTL})
+}
+
+// NewInMemorySessionStorageWithExpiry Creates a storage that removes sessions
+// according to the given expiry rules. See NewInMemorySessionStorage().
+func NewInMemorySessionStorageWithExpiry(frequency time.Duration, expiry SessionExpiry) Storage {
+	sessionTTL := expiry.Idle
 	s := &inMemoryStore{
-		store:    sync.Map{},
-		vacuumch: make(chan bool),
-		ticker:   time.NewTicker(frequency),
+		store:       sync.Map{},
+		vacuumch:    make(chan bool),
+		ticker:      time.NewTicker(frequency),
+		maxLifetime: expiry.MaxLifetime,
 	}
 	go func(store *inMemoryStore) {
 		for {
@@ -0,0 +1,34 @@
+package grouter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionExpiryRules(t *testing.T) {
+	var (
+		created = time.Date(2024, 7, 31, 8, 0, 0, 0, time.UTC)
+		now     = created.Add(10 * time.Minute)
+		session = &decodedSession{record: sessionRecord{
+			ID:           "expiry",
+			CreatedAt:    created,
+			LastAccessed: now.Add(-time.Minute),
+		}}
+	)
+	tests := []struct {
+		expiry  SessionExpiry
+		expired bool
+	}{
+		{SessionExpiry{}, false},
+		{SessionExpiry{Idle: 2 * time.Minute}, false},
+		{SessionExpiry{Idle: time.Minute}, true},
+		{SessionExpiry{Idle: 2 * time.Minute, MaxLifetime: 11 * time.Minute}, false},
+		{SessionExpiry{Idle: 2 * time.Minute, MaxLifetime: 10 * time.Minute}, true},
+		{SessionExpiry{MaxLifetime: 10 * time.Minute}, true},
+	}
+	for _, test := range tests {
+		if expired := test.expiry.Expired(session, now); expired != test.expired {
+			t.Errorf("%+v: expected expired=%t, got %t", test.expiry, test.expired, expired)
+		}
+	}
+}
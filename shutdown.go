@@ -0,0 +1,69 @@
+package grouter
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Flusher Implemented by storages that buffer writes. The engine flushes the
+// storage when it is closed.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Registers a request as in-flight. Returns false if the engine is closed.
+func (e *Engine) acquire() bool {
+	e.lifecycle.Lock()
+	defer e.lifecycle.Unlock()
+	if e.closed {
+		return false
+	}
+	e.inflight.Add(1)
+	return true
+}
+
+// Close Shuts the engine down gracefully.
+//
+// New requests are rejected with the message configured through
+// WithShutdownMessage(). Close then waits for in-flight requests to
+// complete, cancels operations started through Defer(), removes sessions
+// still within their end grace period, and
+// flushes and closes the storage if it implements Flusher or io.Closer
+// respectively. Closing the in-memory storage stops its sweeper.
+//
+// Handlers abandoned after their deadline has passed (see
+// WithResponseDeadline()) no longer count as in-flight and are not waited
+// for. They should honour the cancellation of the request context.
+//
+// If the context is done before in-flight requests complete, Close returns
+// the context's error without touching the storage, and can be called again.
+func (e *Engine) Close(ctx context.Context) error {
+	e.lifecycle.Lock()
+	e.closed = true
+	e.lifecycle.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var err error
+	e.closeOnce.Do(func() {
+		e.deferred.cancelAll()
+		e.flushDrops()
+		if flusher, ok := e.Storage.(Flusher); ok {
+			err = errors.Join(err, flusher.Flush(ctx))
+		}
+		if closer, ok := e.Storage.(io.Closer); ok {
+			err = errors.Join(err, closer.Close())
+		}
+	})
+	return err
+}
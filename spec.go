@@ -0,0 +1,150 @@
+package grouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MenuSpec Declarative definition of a menu option and its sub options, as
+// loaded from a JSON or YAML file. A spec file contains a list of top level
+// options, usually just the index screen.
+//
+// How the screen of an option is rendered is determined by the first of the
+// following that is set:
+//
+//   - Handler: the name of a handler in the HandlerRegistry
+//   - Template: a template rendered with the subscriber's MSISDN as `Phone`
+//   - Children with labels: the Title followed by a line for each labelled
+//     child in the form `<code>. <label>`
+type MenuSpec struct {
+	Code     string      `json:"code" yaml:"code"`
+	Name     string      `json:"name" yaml:"name"`
+	Label    string      `json:"label,omitempty" yaml:"label,omitempty"`
+	Title    string      `json:"title,omitempty" yaml:"title,omitempty"`
+	Handler  string      `json:"handler,omitempty" yaml:"handler,omitempty"`
+	Template string      `json:"template,omitempty" yaml:"template,omitempty"`
+	Children []*MenuSpec `json:"children,omitempty" yaml:"children,omitempty"`
+}
+
+// HandlerRegistry Route handlers referenced by name from menu specs.
+type HandlerRegistry map[string]RouteHandler
+
+// ParseMenuSpec Parses a menu spec in the given format, which is either
+// `json` or `yaml`.
+func ParseMenuSpec(data []byte, format string) ([]*MenuSpec, error) {
+	var (
+		specs []*MenuSpec
+		err   error
+	)
+	switch strings.ToLower(format) {
+	case "json":
+		err = json.Unmarshal(data, &specs)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(data, &specs)
+	default:
+		return nil, fmt.Errorf("menu spec: unsupported format `%s`", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("menu spec: %w", err)
+	}
+	return specs, nil
+}
+
+// LoadMenuSpec Loads a menu spec from a file. The format is determined by
+// the file extension.
+func LoadMenuSpec(fsys fs.FS, name string) ([]*MenuSpec, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMenuSpec(data, strings.TrimPrefix(path.Ext(name), "."))
+}
+
+// BuildMenu Builds the menu options described by the specs.
+func BuildMenu(specs []*MenuSpec, handlers HandlerRegistry) ([]*MenuOption, error) {
+	opts := make([]*MenuOption, 0, len(specs))
+	for _, spec := range specs {
+		opt, err := spec.build(handlers)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+// ValidateMenuSpec Builds the menu options described by the specs and checks
+// them using ValidateMenu(), without registering them.
+func ValidateMenuSpec(specs []*MenuSpec, handlers HandlerRegistry) error {
+	opts, err := BuildMenu(specs, handlers)
+	if err != nil {
+		return err
+	}
+	return ValidateMenu(opts...)
+}
+
+func (spec *MenuSpec) build(handlers HandlerRegistry) (*MenuOption, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("menu spec: empty option")
+	}
+	sub, err := BuildMenu(spec.Children, handlers)
+	if err != nil {
+		return nil, err
+	}
+	h, err := spec.handler(handlers)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := newMenuOption(spec.Code, h, spec.Name, sub...)
+	if err != nil {
+		return nil, fmt.Errorf("menu spec: %w", err)
+	}
+	opt.label = spec.Label
+	opt.handlerName = spec.handlerName()
+	return opt, nil
+}
+
+func (spec *MenuSpec) handlerName() string {
+	switch {
+	case spec.Handler != "":
+		return spec.Handler
+	case spec.Template != "":
+		return "template " + spec.Template
+	}
+	return "menu"
+}
+
+func (spec *MenuSpec) handler(handlers HandlerRegistry) (RouteHandler, error) {
+	switch {
+	case spec.Handler != "":
+		if h, ok := handlers[spec.Handler]; ok && h != nil {
+			return h, nil
+		}
+		return nil, fmt.Errorf("menu spec: %s: handler `%s` not found", spec.Name, spec.Handler)
+	case spec.Template != "":
+		tmplName := spec.Template
+		return func(request UssdRequest) bool {
+			request.ContinueWithTemplate(tmplName, TemplateValues{"Phone": request.MSISDN()})
+			return false
+		}, nil
+	}
+	lines := nonEmpty(spec.Title)
+	for _, child := range spec.Children {
+		if child != nil && child.Label != "" {
+			lines = append(lines, fmt.Sprintf("%s. %s", child.Code, child.Label))
+		}
+	}
+	if len(lines) <= len(nonEmpty(spec.Title)) {
+		return nil, fmt.Errorf("menu spec: %s: option needs a handler, a template or labelled children", spec.Name)
+	}
+	text := NewLineStrings(lines...)
+	return func(request UssdRequest) bool {
+		request.Continue("%s", text)
+		return false
+	}, nil
+}
@@ -1,59 +1,58 @@
 package grouter
 
 import (
-	"sync"
-	"time"
+	"slices"
 )
 
-// Routing state. This is attached to a Session
-type routerState struct {
-	state     string
-	timestamp time.Time
-}
+// Routing state. The screens visited are kept in the session, the last one
+// being the current screen, so that the state is saved through the Storage
+// together with the session and a request can be handled by any replica
+// sharing the storage.
+//
+// The history is never modified in place since an abandoned handler may still
+// be reading it. Changes are made on a copy which then replaces the stored
+// value.
+const historyKey = "grouter.history"
 
-type stateCache struct {
-	store  sync.Map
-	ticker *time.Ticker
-	done   chan bool
+// Returns the navigation history of the session
+func screenHistory(session UssdSession) []string {
+	var history []string
+	LoadSessionValue(session, historyKey, &history)
+	return history
 }
 
-func newStateCache(frequency, stateTTL time.Duration) *stateCache {
-	c := &stateCache{
-		ticker: time.NewTicker(frequency),
-		done:   make(chan bool),
+// Returns the current screen of the session
+func currentScreen(session UssdSession) string {
+	if history := screenHistory(session); len(history) > 0 {
+		return history[len(history)-1]
 	}
-	go func(store *stateCache) {
-		for {
-			select {
-			case <-store.done:
-				store.ticker.Stop()
-				return
-			case <-store.ticker.C:
-				store.evict(stateTTL)
-			}
-		}
-	}(c)
-	return c
+	return ""
 }
 
-func (c *stateCache) get(name string) (string, bool) {
-	if vi, ok := c.store.Load(name); ok {
-		return vi.(*routerState).state, ok
+// Sets the current screen, adding it to the navigation history
+func pushScreen(session UssdSession, screen string) {
+	history := slices.Clone(screenHistory(session))
+	if len(history) == 0 || history[len(history)-1] != screen {
+		history = append(history, screen)
 	}
-	return "", false
+	session.Set(historyKey, history)
 }
 
-func (c *stateCache) set(name string, state string) {
-	c.store.Store(name, &routerState{timestamp: time.Now(), state: state})
+// Removes the current screen from the navigation history and returns the
+// previous one. An empty string is returned when there is no previous screen.
+func popScreen(session UssdSession) string {
+	history := slices.Clone(screenHistory(session))
+	if len(history) > 0 {
+		history = history[:len(history)-1]
+	}
+	session.Set(historyKey, history)
+	if len(history) > 0 {
+		return history[len(history)-1]
+	}
+	return ""
 }
 
-func (c *stateCache) evict(ttl time.Duration) {
-	now := time.Now()
-	c.store.Range(func(key, value any) bool {
-		state := value.(*routerState)
-		if now.Sub(state.timestamp) >= ttl {
-			c.store.Delete(key)
-		}
-		return true
-	})
+// Clears the navigation history
+func resetScreens(session UssdSession) {
+	session.Del(historyKey)
 }
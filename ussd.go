@@ -1,5 +1,7 @@
 package grouter
 
+import "context"
+
 type RouterState int
 
 const (
@@ -18,12 +20,23 @@ const (
 	READ_OPTION
 )
 
+// RetryAware Implemented by requests that can tell when the gateway retries
+// the previous request of a session, e.g. because the response was lost. The
+// engine sends the previous response again instead of routing a retried
+// request.
+type RetryAware interface {
+	Retried() bool
+}
+
 // UssdRequest UssdRequest interface to access various states and data from
 // the USSD request session.
 type UssdRequest interface {
 	// MSISDN MSISDN returns the mobile subscriber identification number
 	// assigned to the user by their network.
 	MSISDN() string
+	// ServiceCode ServiceCode returns the USSD code dialled by the user,
+	// e.g. `*384*100#`.
+	ServiceCode() string
 	// Option Option returns the value entererd by the user after calling any
 	// of the .Continue functions.
 	//
@@ -36,6 +49,10 @@ type UssdRequest interface {
 	Input() string
 	// returns the session associated with this request
 	Session() UssdSession
+	// Context Context returns the context of the request. It is cancelled
+	// when the response deadline configured on the engine, or the timeout of
+	// the matched menu option, passes.
+	Context() context.Context
 	// Continue This function causes the next input to be treated as an option
 	// that will be handled by the routing engine to match a handler
 	Continue(text string, args ...any)
@@ -56,6 +73,12 @@ type UssdRequest interface {
 	// End Ends the session
 	End(text string, args ...any)
 	EndWithTemplate(tmplName string, values TemplateValues)
+	// Redirect Runs the handler of the named screen within the same request.
+	//
+	// Any response written so far is discarded and the routing state is
+	// updated according to the target handler. The calling handler should
+	// return right after redirecting; its return value is ignored.
+	Redirect(screenName string)
 	// SetAttribute Set a request attribute.
 	//
 	// Request attributes are only valid for the duration of the request.
@@ -0,0 +1,154 @@
+package grouter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MenuError A problem found in a menu tree.
+type MenuError struct {
+	// Screen the option belongs to
+	Screen string
+	// Code of the offending option
+	Code string
+	// Name of the offending option
+	Name   string
+	Reason string
+}
+
+func (e MenuError) Error() string {
+	return fmt.Sprintf("screen `%s`, option `%s` (code `%s`): %s", e.Screen, e.Name, e.Code, e.Reason)
+}
+
+// MenuErrors All the problems found in a menu tree.
+type MenuErrors []MenuError
+
+func (e MenuErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid menu: %d error(s):\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+type menuNode struct {
+	opt    *MenuOption
+	screen string
+}
+
+func flattenMenu(nodes []menuNode, opt *MenuOption, screen string) []menuNode {
+	if opt == nil {
+		return nodes
+	}
+	nodes = append(nodes, menuNode{opt: opt, screen: screen})
+	for _, sub := range opt.sub {
+		nodes = flattenMenu(nodes, sub, opt.name)
+	}
+	return nodes
+}
+
+// Returns the code pointer of a handler function, or 0 if it is nil
+func handlerPointer(h any) uintptr {
+	v := reflect.ValueOf(h)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return 0
+	}
+	return v.Pointer()
+}
+
+// ValidateMenu Checks a menu tree for mistakes that would otherwise only show
+// up at runtime. The returned error, if any, is of type MenuErrors and lists
+// every problem found:
+//
+//   - options without a handler
+//   - more than one option with the same code in a screen
+//   - more than one index screen, or none at all
+//   - top level options that can never be reached from the index screen
+//   - screens whose options all lead back to the same screen
+//   - names reused for different handlers, or for more than one screen with
+//     options, which makes the options of those screens merge into one
+//   - the same option added to more than one screen. An option belongs to a
+//     single screen and only matches in the last one it is added to
+func ValidateMenu(opts ...*MenuOption) error {
+	var (
+		errs     MenuErrors
+		nodes    []menuNode
+		index    []*MenuOption
+		codes    = map[string]*MenuOption{}
+		handlers = map[string]*MenuOption{}
+		screens  = map[string]*MenuOption{}
+		parents  = map[*MenuOption]string{}
+	)
+	report := func(node menuNode, reason string, args ...any) {
+		errs = append(errs, MenuError{
+			Screen: node.screen,
+			Code:   node.opt.code,
+			Name:   node.opt.name,
+			Reason: fmt.Sprintf(reason, args...),
+		})
+	}
+	for _, opt := range opts {
+		if opt != nil && opt.code == "" {
+			index = append(index, opt)
+		}
+		nodes = flattenMenu(nodes, opt, "")
+	}
+	if len(index) == 0 {
+		errs = append(errs, MenuError{Reason: "no index screen (top level option with an empty code)"})
+	}
+	for _, node := range nodes {
+		opt := node.opt
+		if screen, ok := parents[opt]; ok {
+			// The options of a shared screen are visited again under the same
+			// parent, and are only reported once, through the screen.
+			if screen != node.screen {
+				report(node, "option is already added to screen `%s`, use a separate option for each screen", screen)
+			}
+			continue
+		}
+		parents[opt] = node.screen
+		if opt.handler == nil {
+			report(node, "handler is nil")
+		}
+		if node.screen == "" && opt.code == "" && opt != index[0] {
+			report(node, "index screen is already set to `%s`", index[0].name)
+		}
+		if node.screen == "" && opt.code != "" {
+			report(node, "not reachable from the index screen")
+		}
+		codeKey := node.screen + "\x00" + opt.code
+		if other, ok := codes[codeKey]; ok {
+			report(node, "code is already used by option `%s`", other.name)
+		} else {
+			codes[codeKey] = opt
+		}
+		if other, ok := handlers[opt.name]; ok {
+			if other.handlerID != opt.handlerID {
+				report(node, "name is already used by an option with a different handler")
+			}
+		} else {
+			handlers[opt.name] = opt
+		}
+		if len(opt.sub) > 0 {
+			if _, ok := screens[opt.name]; ok {
+				report(node, "screen is already defined, the options of both screens would be merged")
+			} else {
+				screens[opt.name] = opt
+			}
+			wayOut := false
+			for _, sub := range opt.sub {
+				if sub != nil && sub.name != opt.name {
+					wayOut = true
+				}
+			}
+			if !wayOut {
+				report(node, "screen has no option leading to another screen")
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
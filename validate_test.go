@@ -0,0 +1,89 @@
+package grouter
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateMenu(t *testing.T) {
+	show := func(request UssdRequest) bool { return false }
+	other := func(request UssdRequest) bool { return true }
+	err := ValidateMenu(
+		NewMenuOption("", show, "home",
+			NewMenuOption("1", show, "account",
+				NewMenuOption("1", show, "account"),
+			),
+			NewMenuOption("1", nil, "duplicate"),
+			NewMenuOption("2", other, "home"),
+		),
+		NewMenuOption("9", show, "orphan"),
+	)
+	var errs MenuErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected MenuErrors, got %v", err)
+	}
+	expected := map[string]string{
+		"account":   "screen has no option leading to another screen",
+		"duplicate": "code is already used by option `account`",
+		"orphan":    "not reachable from the index screen",
+		"home":      "name is already used by an option with a different handler",
+	}
+	found := map[string]bool{}
+	for _, e := range errs {
+		if expected[e.Name] == e.Reason {
+			found[e.Name] = true
+		}
+	}
+	for name := range expected {
+		if !found[name] {
+			t.Errorf("%s: expected error %q in %v", name, expected[name], errs)
+		}
+	}
+
+	valid := ValidateMenu(
+		NewMenuOption("", show, "home",
+			NewMenuOption("1", show, "account",
+				NewMenuOption("#", show, "home"),
+			),
+			NewMenuOption("#", show, "end"),
+		),
+	)
+	if valid != nil {
+		t.Errorf("unexpected error: %v", valid)
+	}
+
+	// Separate options may share a name on different screens
+	leaves := ValidateMenu(
+		NewMenuOption("", show, "home",
+			NewMenuOption("1", show, "account", NewMenuOption("0", show, "exit")),
+			NewMenuOption("0", show, "exit"),
+		),
+	)
+	if leaves != nil {
+		t.Errorf("unexpected error for options sharing a name: %v", leaves)
+	}
+
+	// but the same option only belongs to the last screen it is added to
+	exit := NewMenuOption("0", show, "exit")
+	shared := ValidateMenu(
+		NewMenuOption("", show, "home",
+			NewMenuOption("1", show, "account", exit),
+			exit,
+		),
+	)
+	if !errors.As(shared, &errs) || len(errs) != 1 || errs[0].Screen != "home" {
+		t.Errorf("expected shared option error for screen home, got %v", shared)
+	}
+
+	specs, err := ParseMenuSpec([]byte(`[{"code": "", "name": "home", "title": "Home", "children": [
+		{"code": "1", "name": "x", "label": "A", "template": "a.tmpl", "children": [
+			{"code": "1", "name": "a", "label": "A", "template": "a.tmpl"}]},
+		{"code": "2", "name": "x", "label": "B", "template": "b.tmpl", "children": [
+			{"code": "1", "name": "b", "label": "B", "template": "b.tmpl"}]}]}]`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateMenuSpec(specs, nil); err == nil {
+		t.Errorf("expected merged screens error for spec templates")
+	}
+}
@@ -0,0 +1,176 @@
+package grouter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validator Checks input entered at a prompt. The error message is shown to
+// the user above the prompt.
+type Validator func(input string) error
+
+const lastResponseKey = "grouter.lastResponse"
+
+// Validate Adds validators for input entered at the prompt of this option's
+// handler. The handler is only called with input that passes all the
+// validators. Otherwise the engine shows the prompt again, with the error
+// message as a banner, and the screen context is kept.
+func (o *MenuOption) Validate(validators ...Validator) *MenuOption {
+	o.validators = append(o.validators, validators...)
+	return o
+}
+
+// Checks the input of a request read at a prompt
+func (o *MenuOption) validate(request UssdRequest) error {
+	if request.Input() == "" {
+		return nil
+	}
+	for _, v := range o.validators {
+		if err := v(request.Input()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shows the last prompt sent in the session again, under the error message
+func (e *Engine) reprompt(rc *routing, err error) {
+	rc.writer.reset()
+	if value, ok := rc.request.Session().Get(lastResponseKey); ok {
+		if last, _ := value.(string); last != "" {
+			rc.writer.Printf("%s", last)
+			rc.writer.banner = err.Error()
+			return
+		}
+	}
+	rc.request.Prompt("%s", err.Error())
+}
+
+// Message Returns a validator that fails with the given text instead of the
+// message of this validator.
+func (v Validator) Message(text string) Validator {
+	return func(input string) error {
+		if v(input) != nil {
+			return errors.New(text)
+		}
+		return nil
+	}
+}
+
+// All Returns a validator that fails with the first failing validator.
+func All(validators ...Validator) Validator {
+	return func(input string) error {
+		for _, v := range validators {
+			if err := v(input); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+var (
+	digitsExpr = regexp.MustCompile(`^\d+$`)
+	// E.164, with or without the leading `+`
+	msisdnExpr = regexp.MustCompile(`^\+?[1-9]\d{7,14}$`)
+)
+
+// Numeric Accepts digits only.
+func Numeric() Validator {
+	return func(input string) error {
+		if !digitsExpr.MatchString(input) {
+			return errors.New("Enter digits only")
+		}
+		return nil
+	}
+}
+
+// IntRange Accepts integers within the (inclusive) range.
+func IntRange(min, max int) Validator {
+	return func(input string) error {
+		if n, err := strconv.Atoi(input); err != nil || n < min || n > max {
+			return fmt.Errorf("Enter a number from %d to %d", min, max)
+		}
+		return nil
+	}
+}
+
+// Amount Accepts positive amounts with at most the given number of decimal
+// places, e.g. `1500` or `1500.50`.
+func Amount(decimals int) Validator {
+	expr := regexp.MustCompile(`^\d+$`)
+	if decimals > 0 {
+		expr = regexp.MustCompile(fmt.Sprintf(`^\d+(\.\d{1,%d})?$`, decimals))
+	}
+	return func(input string) error {
+		if !expr.MatchString(input) {
+			return errors.New("Enter a valid amount")
+		}
+		if value, err := strconv.ParseFloat(input, 64); err != nil || value <= 0 {
+			return errors.New("Enter an amount greater than zero")
+		}
+		return nil
+	}
+}
+
+// MSISDN Accepts phone numbers in the E.164 format, with or without the
+// leading `+`.
+func MSISDN() Validator {
+	return func(input string) error {
+		if !msisdnExpr.MatchString(input) {
+			return errors.New("Enter a valid phone number")
+		}
+		return nil
+	}
+}
+
+// PIN Accepts PINs of exactly the given number of digits.
+func PIN(length int) Validator {
+	return func(input string) error {
+		if len(input) != length || !digitsExpr.MatchString(input) {
+			return fmt.Errorf("Enter a %d digit PIN", length)
+		}
+		return nil
+	}
+}
+
+// Date Accepts dates in the given layout, see time.Parse(). Use
+// Validator.Message() to describe the layout to the user, e.g.
+//
+//	grouter.Date("02/01/2006").Message("Enter a date as DD/MM/YYYY")
+func Date(layout string) Validator {
+	return func(input string) error {
+		if _, err := time.Parse(layout, input); err != nil {
+			return errors.New("Enter a valid date")
+		}
+		return nil
+	}
+}
+
+// Pattern Accepts input matching the regular expression. It panics if the
+// expression is invalid.
+func Pattern(expr string, message string) Validator {
+	re := regexp.MustCompile(expr)
+	return func(input string) error {
+		if !re.MatchString(input) {
+			return errors.New(message)
+		}
+		return nil
+	}
+}
+
+// OneOf Accepts one of the given values.
+func OneOf(values ...string) Validator {
+	return func(input string) error {
+		for _, value := range values {
+			if input == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("Enter one of %s", strings.Join(values, ", "))
+	}
+}
@@ -0,0 +1,38 @@
+package grouter
+
+import "testing"
+
+func TestBuiltinValidators(t *testing.T) {
+	cases := []struct {
+		name      string
+		validator Validator
+		valid     []string
+		invalid   []string
+	}{
+		{"numeric", Numeric(), []string{"0", "0123"}, []string{"", "12a", "-1", "1.5"}},
+		{"int range", IntRange(1, 12), []string{"1", "12"}, []string{"0", "13", "x"}},
+		{"amount", Amount(2), []string{"1", "1500.5", "1500.50"}, []string{"0", "0.00", "1.505", "-5", "1,500"}},
+		{"whole amount", Amount(0), []string{"100"}, []string{"100.5"}},
+		{"msisdn", MSISDN(), []string{"+265888000000", "265888000000"}, []string{"0888000000", "+2658880000000000", "abc"}},
+		{"pin", PIN(4), []string{"0000", "1234"}, []string{"123", "12345", "12a4"}},
+		{"date", Date("02/01/2006"), []string{"31/12/2024"}, []string{"31/13/2024", "2024-12-31"}},
+		{"pattern", Pattern(`^[A-Z]{2}\d{4}$`, "Invalid code"), []string{"AB1234"}, []string{"ab1234", "AB123"}},
+		{"one of", OneOf("yes", "no"), []string{"yes", "no"}, []string{"maybe", ""}},
+		{"all", All(Numeric(), IntRange(1, 5)), []string{"3"}, []string{"6", "x"}},
+	}
+	for _, c := range cases {
+		for _, input := range c.valid {
+			if err := c.validator(input); err != nil {
+				t.Errorf("%s: expected %q to be valid, got %v", c.name, input, err)
+			}
+		}
+		for _, input := range c.invalid {
+			if err := c.validator(input); err == nil {
+				t.Errorf("%s: expected %q to be invalid", c.name, input)
+			}
+		}
+	}
+	if err := PIN(4).Message("Wrong PIN")("1"); err == nil || err.Error() != "Wrong PIN" {
+		t.Errorf("expected custom message, got %v", err)
+	}
+}